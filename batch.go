@@ -0,0 +1,68 @@
+package gmgo
+
+import (
+	"iter"
+)
+
+// BatchStats reports the totals processed by ForEachBatch
+type BatchStats struct {
+	Batches   int
+	Documents int
+}
+
+// ForEachBatch reads the documents of the DocumentIterator in batches and calls fn for each batch. The batch size
+// is the given size, or IteratorConfig.PageSize if size is zero (100 if neither is set). It stops at the first
+// error returned by fn or the cursor and returns it along with the totals of the batches processed successfully.
+// The batch slice is reused between calls, so fn must copy it to keep it.
+//
+// For example:
+//
+//	itr := session.DocumentIterator(gmgo.Q{"state": "CA"}, "user")
+//	itr.Load(gmgo.IteratorConfig{PageSize: 500})
+//	stats, err := gmgo.ForEachBatch(itr, 0, func(users []*user) error {
+//		return bulkUpdate(users)
+//	})
+func ForEachBatch[T any](pd *DocumentIterator, size int, fn func(batch []T) error) (BatchStats, error) {
+	pd.loadInternal()
+	if size <= 0 {
+		size = pd.pageSize
+	}
+	if size <= 0 {
+		size = 100
+	}
+	return forEachBatch(Documents[T](pd), size, fn)
+}
+
+// forEachBatch accumulates the documents of the sequence in batches of the given size
+func forEachBatch[T any](docs iter.Seq2[T, error], size int, fn func(batch []T) error) (BatchStats, error) {
+	stats := BatchStats{}
+	batch := make([]T, 0, size)
+	flush := func() error {
+		if err := fn(batch); err != nil {
+			return err
+		}
+		stats.Batches++
+		stats.Documents += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for d, err := range docs {
+		if err != nil {
+			return stats, err
+		}
+		batch = append(batch, d)
+		if len(batch) == size {
+			if err := flush(); err != nil {
+				return stats, err
+			}
+		}
+	}
+
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
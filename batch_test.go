@@ -0,0 +1,50 @@
+package gmgo
+
+import (
+	"errors"
+	"testing"
+)
+
+func intSeq(n int, err error) func(yield func(int, error) bool) {
+	return func(yield func(int, error) bool) {
+		for i := 0; i < n; i++ {
+			if !yield(i, nil) {
+				return
+			}
+		}
+		if err != nil {
+			yield(0, err)
+		}
+	}
+}
+
+func TestForEachBatch(t *testing.T) {
+	var sizes []int
+	stats, err := forEachBatch(intSeq(7, nil), 3, func(batch []int) error {
+		sizes = append(sizes, len(batch))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if stats.Batches != 3 || stats.Documents != 7 || len(sizes) != 3 || sizes[2] != 1 {
+		t.Errorf("Unexpected stats %+v, batch sizes %v", stats, sizes)
+	}
+
+	failed := errors.New("bulk update failed")
+	stats, err = forEachBatch(intSeq(7, nil), 3, func(batch []int) error {
+		if batch[0] == 3 {
+			return failed
+		}
+		return nil
+	})
+	if err != failed || stats.Batches != 1 || stats.Documents != 3 {
+		t.Errorf("Expected to stop at the second batch, got %+v and %v", stats, err)
+	}
+
+	cursorErr := errors.New("cursor died")
+	stats, err = forEachBatch(intSeq(4, cursorErr), 3, func(batch []int) error { return nil })
+	if err != cursorErr || stats.Documents != 3 {
+		t.Errorf("Expected cursor error after the first batch, got %+v and %v", stats, err)
+	}
+}
@@ -0,0 +1,266 @@
+package gmgo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/narup/gmgo/extjson"
+)
+
+// DefaultCheckpointCollection is the collection used by CollectionCheckpointStore when none is given
+const DefaultCheckpointCollection = "gmgo_checkpoints"
+
+// Checkpoint is the position of a resumable DocumentIterator
+type Checkpoint struct {
+	Name string `bson:"_id"`
+	// LastID is the _id of the last document processed
+	LastID interface{} `bson:"lastId"`
+	// Count is the number of documents processed since the iteration first started
+	Count     int       `bson:"count"`
+	UpdatedAt time.Time `bson:"updatedAt"`
+}
+
+// CheckpointStore persists checkpoints of resumable iterators
+type CheckpointStore interface {
+	// LoadCheckpoint returns the checkpoint with the given name, or nil if there's none
+	LoadCheckpoint(name string) (*Checkpoint, error)
+	// SaveCheckpoint persists the checkpoint, replacing the previous one with the same name
+	SaveCheckpoint(c Checkpoint) error
+}
+
+// CheckpointConfig makes the DocumentIterator resumable. The documents are iterated in _id order and
+// the _id of the last processed document is saved every Interval documents, so a new iterator with the
+// same checkpoint name resumes after it. A document is considered processed when FetchNext is called again
+// after it, which gives at-least-once delivery: documents processed after the last checkpoint are delivered
+// again after a restart.
+//
+// For example:
+//
+//	itr := session.DocumentIterator(gmgo.Q{"state": "CA"}, "user")
+//	itr.Load(gmgo.IteratorConfig{PageSize: 500, Checkpoint: &gmgo.CheckpointConfig{Name: "user-backfill", Interval: 500}})
+//	usr := new(user)
+//	for itr.FetchNext(usr) {
+//		process(usr)
+//	}
+//	if err := itr.Error(); err != nil {
+//		// resume later from the last checkpoint
+//	}
+type CheckpointConfig struct {
+	// Name identifies the checkpoint in the store
+	Name string
+	// Store persists the checkpoints. Defaults to CollectionCheckpointStore in the iterator's database
+	Store CheckpointStore
+	// Interval is the number of documents between checkpoints. Defaults to 1000
+	Interval int
+	// OnCommit is called after each checkpoint is saved. Returning an error stops the iteration.
+	OnCommit func(c Checkpoint) error
+}
+
+// CollectionCheckpointStore stores checkpoints as documents of a MongoDB collection
+type CollectionCheckpointStore struct {
+	Session    *DbSession
+	Collection string
+}
+
+// NewCollectionCheckpointStore creates the checkpoint store using the DefaultCheckpointCollection
+func NewCollectionCheckpointStore(session *DbSession) *CollectionCheckpointStore {
+	return &CollectionCheckpointStore{Session: session, Collection: DefaultCheckpointCollection}
+}
+
+// LoadCheckpoint returns the checkpoint with the given name, or nil if there's none
+func (cs *CollectionCheckpointStore) LoadCheckpoint(name string) (*Checkpoint, error) {
+	c := new(Checkpoint)
+	err := cs.Session.retry(false, func() error {
+		return cs.Session.collection(cs.Collection).FindId(name).One(c)
+	})
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SaveCheckpoint persists the checkpoint
+func (cs *CollectionCheckpointStore) SaveCheckpoint(c Checkpoint) error {
+	return cs.Session.retry(false, func() error {
+		_, err := cs.Session.collection(cs.Collection).UpsertId(c.Name, c)
+		return err
+	})
+}
+
+// FileCheckpointStore stores checkpoints as files of a local directory, one canonical Extended JSON file per
+// checkpoint name, so the type of the last id is preserved. Files are replaced atomically.
+type FileCheckpointStore struct {
+	Dir string
+}
+
+// path returns the path of the checkpoint file, rejecting names that aren't plain file names
+func (fs FileCheckpointStore) path(name string) (string, error) {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid checkpoint name %q", name)
+	}
+	return filepath.Join(fs.Dir, name+".json"), nil
+}
+
+// LoadCheckpoint returns the checkpoint with the given name, or nil if there's none
+func (fs FileCheckpointStore) LoadCheckpoint(name string) (*Checkpoint, error) {
+	p, err := fs.path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := extjson.Unmarshal(data)
+	if err != nil {
+		return nil, fmt.Errorf("checkpoint %s: %s", p, err)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	c := new(Checkpoint)
+	if err := bson.Unmarshal(raw, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// SaveCheckpoint writes the checkpoint to a temporary file renamed over the previous one
+func (fs FileCheckpointStore) SaveCheckpoint(c Checkpoint) error {
+	p, err := fs.path(c.Name)
+	if err != nil {
+		return err
+	}
+	raw, err := bson.Marshal(c)
+	if err != nil {
+		return err
+	}
+	doc := bson.D{}
+	if err := bson.Unmarshal(raw, &doc); err != nil {
+		return err
+	}
+	data, err := extjson.Marshal(doc, extjson.Canonical)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(fs.Dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(fs.Dir, ".checkpoint-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// checkpointState tracks the position of a resumable iterator
+type checkpointState struct {
+	config  CheckpointConfig
+	last    Checkpoint
+	pending int
+}
+
+// loadCheckpoint applies the saved checkpoint, if any, to the iterator query
+func (pd *DocumentIterator) loadCheckpoint(cfg CheckpointConfig) error {
+	if cfg.Store == nil {
+		cfg.Store = NewCollectionCheckpointStore(pd.session)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 1000
+	}
+
+	state := &checkpointState{config: cfg, last: Checkpoint{Name: cfg.Name}}
+	saved, err := cfg.Store.LoadCheckpoint(cfg.Name)
+	if err != nil {
+		return err
+	}
+
+	filter := pd.filter
+	if filter == nil {
+		filter = Q{}
+	}
+	if saved != nil {
+		state.last = *saved
+		filter = Q{"$and": []interface{}{filter, bson.M{"_id": bson.M{"$gt": saved.LastID}}}}
+	}
+	pd.filter = filter
+	pd.query = pd.session.findQueryByCollectionName(pd.collection, filter)
+	pd.checkpoint = state
+	return nil
+}
+
+// fetchNextWithCheckpoint commits the checkpoint when due and decodes the next document
+func (pd *DocumentIterator) fetchNextWithCheckpoint(d interface{}) bool {
+	cs := pd.checkpoint
+	if cs.pending >= cs.config.Interval {
+		if pd.err = pd.commitCheckpoint(); pd.err != nil {
+			return false
+		}
+	}
+
+	raw := bson.Raw{}
+	if !pd.iterator.Next(&raw) {
+		if pd.err = pd.iterator.Err(); pd.err == nil && cs.pending > 0 {
+			pd.err = pd.commitCheckpoint()
+		}
+		return false
+	}
+
+	id := struct {
+		ID interface{} `bson:"_id"`
+	}{}
+	if pd.err = raw.Unmarshal(&id); pd.err != nil {
+		return false
+	}
+	if pd.err = raw.Unmarshal(d); pd.err != nil {
+		return false
+	}
+
+	cs.last.LastID = id.ID
+	cs.last.Count++
+	cs.pending++
+	return true
+}
+
+// commitCheckpoint saves the position of the last processed document and calls the commit hook
+func (pd *DocumentIterator) commitCheckpoint() error {
+	cs := pd.checkpoint
+	cs.last.UpdatedAt = time.Now()
+	if err := cs.config.Store.SaveCheckpoint(cs.last); err != nil {
+		return err
+	}
+	cs.pending = 0
+
+	if cs.config.OnCommit != nil {
+		return cs.config.OnCommit(cs.last)
+	}
+	return nil
+}
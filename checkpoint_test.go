@@ -0,0 +1,95 @@
+package gmgo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+type memoryCheckpointStore map[string]Checkpoint
+
+func (m memoryCheckpointStore) LoadCheckpoint(name string) (*Checkpoint, error) {
+	if c, ok := m[name]; ok {
+		return &c, nil
+	}
+	return nil, nil
+}
+
+func (m memoryCheckpointStore) SaveCheckpoint(c Checkpoint) error {
+	m[c.Name] = c
+	return nil
+}
+
+func TestCommitCheckpoint(t *testing.T) {
+	store := memoryCheckpointStore{}
+	commits := 0
+	pd := &DocumentIterator{checkpoint: &checkpointState{
+		config: CheckpointConfig{Name: "backfill", Store: store, Interval: 2, OnCommit: func(c Checkpoint) error {
+			commits++
+			return nil
+		}},
+		last:    Checkpoint{Name: "backfill", LastID: 42, Count: 2},
+		pending: 2,
+	}}
+
+	if err := pd.commitCheckpoint(); err != nil {
+		t.Fatalf("Commit failed %s", err)
+	}
+	c, _ := store.LoadCheckpoint("backfill")
+	if c == nil || c.LastID != 42 || c.Count != 2 {
+		t.Errorf("Unexpected checkpoint %+v", c)
+	}
+	if commits != 1 || pd.checkpoint.pending != 0 {
+		t.Errorf("Expected one commit and no pending documents, got %d commits and %d pending", commits, pd.checkpoint.pending)
+	}
+}
+
+func TestFileCheckpointStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gmgo-checkpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	store := FileCheckpointStore{Dir: dir}
+
+	if c, err := store.LoadCheckpoint("tail"); c != nil || err != nil {
+		t.Errorf("Expected no checkpoint, got %+v %v", c, err)
+	}
+
+	updated := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for _, id := range []interface{}{bson.MongoTimestamp(1760000000<<32 | 3), bson.ObjectIdHex("5b8e5f5a1c9d440000a1b2c3")} {
+		c := Checkpoint{Name: "tail", LastID: id, Count: 12, UpdatedAt: updated}
+		if err := store.SaveCheckpoint(c); err != nil {
+			t.Fatalf("Save failed %s", err)
+		}
+		loaded, err := store.LoadCheckpoint("tail")
+		if err != nil {
+			t.Fatalf("Load failed %s", err)
+		}
+		if loaded.LastID != id || loaded.Count != 12 || !loaded.UpdatedAt.Equal(updated) {
+			t.Errorf("Unexpected checkpoint %+v", loaded)
+		}
+	}
+
+	if err := store.SaveCheckpoint(Checkpoint{Name: "../escape"}); err == nil {
+		t.Error("Expected error for invalid name")
+	}
+}
+
+func xxTestResumableIterator(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	itr := session.DocumentIterator(Q{"state": "CA"}, "rexUser")
+	itr.Load(IteratorConfig{PageSize: 100, Checkpoint: &CheckpointConfig{Name: "test-scan", Interval: 10}})
+	usr := new(user)
+	for itr.FetchNext(usr) {
+		println(usr.ID.Hex())
+	}
+	if err := itr.Error(); err != nil {
+		t.Errorf("Iteration failed %s", err)
+	}
+}
@@ -1,7 +1,13 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"github.com/narup/gmgo"
 )
@@ -27,30 +33,99 @@ func (me mongoEventHandler) HandleError(err error) {
 	fmt.Printf("%s", err)
 }
 
+// usage:
+//
+//	gmgo [tail] [-db alias] [-config file]
+//	gmgo import -collection name -file path [options]
+//	gmgo gridfs fsck -bucket prefix [-repair] [options]
+//	gmgo gridfs migrate -bucket prefix -to store -store name=spec [options]
 func main() {
-	session := testDBSession()
+	args := os.Args[1:]
+	command := "tail"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	switch command {
+	case "tail":
+		runTail(args)
+	case "import":
+		runImport(args)
+	case "gridfs":
+		runGridFS(args)
+	default:
+		fmt.Printf("Unknown command %s. Available commands: tail, import, gridfs\n", command)
+		os.Exit(2)
+	}
+}
+
+// dbFlags registers the flags selecting the database connection
+func dbFlags(fs *flag.FlagSet) (alias, configFile *string) {
+	alias = fs.String("db", "default", "database alias, read from GMGO_<ALIAS>_* environment variables or the config file")
+	configFile = fs.String("config", "", "YAML or JSON config file with the database entries")
+	return
+}
+
+func runTail(args []string) {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	alias, configFile := dbFlags(fs)
+	fs.Parse(args)
+
+	session := dbSession(*alias, *configFile)
+	if session == nil {
+		return
+	}
 
 	mt := new(gmgo.MongoTail)
 	mt.EventHandler = mongoEventHandler{}
 
-	mt.Start(session)
+	ctx, cancel := context.WithCancel(context.Background())
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sig
+		cancel()
+	}()
+
+	if err := mt.Start(ctx, session); err != nil {
+		fmt.Printf("Tail failed %s\n", err)
+		os.Exit(1)
+	}
 }
 
-func testDBSession() *gmgo.DbSession {
-	dbConfig := gmgo.DbConfig{HostURL: "mongodb://localhost:27017/phildb", DBName: "phildb", UserName: "", Password: "", Mode: 1}
-	err := gmgo.Setup(dbConfig)
+func dbSession(alias, configFile string) *gmgo.DbSession {
+	var dbConfig gmgo.DbConfig
+	var err error
+	if configFile != "" {
+		var configs map[string]gmgo.DbConfig
+		configs, err = gmgo.LoadConfigFile(configFile)
+		if err == nil {
+			var ok bool
+			if dbConfig, ok = configs[alias]; !ok {
+				err = fmt.Errorf("database %s not found in %s", alias, configFile)
+			}
+		}
+	} else {
+		dbConfig, err = gmgo.LoadEnvConfig(alias)
+	}
+	if err != nil {
+		fmt.Printf("Invalid config %s", err)
+		return nil
+	}
+
+	err = gmgo.Setup(dbConfig)
 	if err != nil {
 		fmt.Printf("Connection failed %s", err)
 		return nil
 	}
 
-	philDB, err := gmgo.Get("phildb")
+	db, err := gmgo.Get(dbConfig.DBName)
 	if err != nil {
 		fmt.Printf("Get db failed %s", err)
 		return nil
 	}
 
-	fmt.Println(philDB.Config.DBName)
+	fmt.Println(db.Config.DBName)
 
-	return philDB.Session()
+	return db.Session()
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/narup/gmgo"
+)
+
+func runGridFS(args []string) {
+	if len(args) == 0 {
+		fmt.Println("Usage: gmgo gridfs fsck|migrate -bucket prefix [options]")
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "fsck":
+		runFsck(args[1:])
+	case "migrate":
+		runMigrate(args[1:])
+	default:
+		fmt.Printf("Unknown gridfs command %s. Available commands: fsck, migrate\n", args[0])
+		os.Exit(2)
+	}
+}
+
+// storeFlags registers the file stores given as name=spec flags, see parseFileStore
+type storeFlags []string
+
+func (sf *storeFlags) String() string {
+	return strings.Join(*sf, ",")
+}
+
+func (sf *storeFlags) Set(v string) error {
+	parts := strings.SplitN(v, "=", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("expected name=spec, got %q", v)
+	}
+	store, err := parseFileStore(parts[1])
+	if err != nil {
+		return err
+	}
+	gmgo.RegisterFileStore(parts[0], store)
+	*sf = append(*sf, v)
+	return nil
+}
+
+// parseFileStore creates the file store from the spec, local:<dir> or s3:<endpoint>/<bucket>. The S3 credentials
+// and region are read from the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION environment variables
+func parseFileStore(spec string) (gmgo.FileStore, error) {
+	switch {
+	case strings.HasPrefix(spec, "local:"):
+		return gmgo.LocalFileStore{Dir: strings.TrimPrefix(spec, "local:")}, nil
+	case strings.HasPrefix(spec, "s3:"):
+		u, err := url.Parse(strings.TrimPrefix(spec, "s3:"))
+		if err != nil || u.Host == "" || path.Base(u.Path) == "/" || path.Base(u.Path) == "." {
+			return nil, fmt.Errorf("invalid s3 store %q, expected s3:<endpoint>/<bucket>", spec)
+		}
+		bucket := path.Base(u.Path)
+		u.Path = path.Dir(u.Path)
+		return &gmgo.S3FileStore{
+			Endpoint:  strings.TrimSuffix(u.String(), "/"),
+			Bucket:    bucket,
+			Region:    os.Getenv("AWS_REGION"),
+			AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown file store %q, expected local:<dir> or s3:<endpoint>/<bucket>", spec)
+}
+
+func runMigrate(args []string) {
+	fs := flag.NewFlagSet("gridfs migrate", flag.ExitOnError)
+	alias, configFile := dbFlags(fs)
+	bucket := fs.String("bucket", "fs", "GridFS bucket prefix")
+	to := fs.String("to", "", "name of the file store to move the content to, empty for GridFS chunks")
+	var stores storeFlags
+	fs.Var(&stores, "store", "file store as name=local:<dir> or name=s3:<endpoint>/<bucket>, repeated for each store files are in")
+	fs.Parse(args)
+
+	session := dbSession(*alias, *configFile)
+	if session == nil {
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	cfg := gmgo.MigrateConfig{Progress: func(stats gmgo.MigrateStats) {
+		fmt.Printf("\rmigrated %d files, %d bytes, skipped %d", stats.Files, stats.Bytes, stats.Skipped)
+	}}
+	stats, err := session.MigrateFiles(*bucket, *to, cfg)
+	fmt.Printf("\rmigrated %d files, %d bytes, skipped %d\n", stats.Files, stats.Bytes, stats.Skipped)
+	if err != nil {
+		fmt.Printf("Migrate failed %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func runFsck(args []string) {
+	fs := flag.NewFlagSet("gridfs fsck", flag.ExitOnError)
+	alias, configFile := dbFlags(fs)
+	bucket := fs.String("bucket", "fs", "GridFS bucket prefix")
+	repair := fs.Bool("repair", false, "remove corrupted files and orphan chunks")
+	grace := fs.Duration("grace", gmgo.DefaultOrphanGracePeriod, "minimum age of the orphan chunks removed by -repair")
+	fs.Parse(args)
+
+	session := dbSession(*alias, *configFile)
+	if session == nil {
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	cfg := gmgo.VerifyConfig{Repair: *repair, OrphanGracePeriod: *grace}
+	cfg.Progress = func(r gmgo.BucketReport) {
+		fmt.Printf("\rverified %d files, %d chunks, %d problems", r.Files, r.Chunks, len(r.Problems))
+	}
+
+	report, err := session.VerifyBucket(*bucket, cfg)
+	fmt.Printf("\rverified %d files, %d chunks, %d problems\n", report.Files, report.Chunks, len(report.Problems))
+	for _, p := range report.Problems {
+		fmt.Println(p)
+	}
+	if err != nil {
+		fmt.Printf("Verify failed %s\n", err)
+		os.Exit(1)
+	}
+	for _, p := range report.Problems {
+		if !p.Repaired {
+			os.Exit(1)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/narup/gmgo/importer"
+)
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	alias, configFile := dbFlags(fs)
+	collection := fs.String("collection", "", "collection to import the documents to")
+	file := fs.String("file", "", "input file, .gz files are decompressed")
+	format := fs.String("format", "", "input format: json, csv or bson. Defaults to the file extension")
+	upsertFields := fs.String("upsert-fields", "", "comma separated fields used to replace existing documents instead of inserting")
+	batchSize := fs.Int("batch-size", importer.DefaultBatchSize, "number of documents per bulk write")
+	maxErrors := fs.Int("max-errors", 0, "number of row errors tolerated before stopping, 0 for no limit")
+	dryRun := fs.Bool("dry-run", false, "validate the input without writing to the database")
+	fs.Parse(args)
+
+	if *collection == "" || *file == "" {
+		fmt.Println("Usage: gmgo import -collection name -file path [options]")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	opts := importer.Options{BatchSize: *batchSize, MaxErrors: *maxErrors, DryRun: *dryRun}
+	if *upsertFields != "" {
+		opts.UpsertFields = strings.Split(*upsertFields, ",")
+	}
+	var err error
+	if opts.Format, err = importFormat(*format, *file); err != nil {
+		fmt.Println(err)
+		os.Exit(2)
+	}
+	opts.Progress = func(stats importer.Stats) {
+		fmt.Printf("\rread %d, inserted %d, replaced %d, failed %d", stats.Read, stats.Inserted, stats.Replaced, stats.Failed)
+	}
+
+	session := dbSession(*alias, *configFile)
+	if session == nil {
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	stats, err := importer.ImportFile(*file, session, *collection, opts)
+	fmt.Printf("\rread %d, inserted %d, replaced %d, failed %d\n", stats.Read, stats.Inserted, stats.Replaced, stats.Failed)
+	for _, rowErr := range stats.Errors {
+		fmt.Println(rowErr)
+	}
+	if err != nil {
+		fmt.Printf("Import failed %s\n", err)
+		os.Exit(1)
+	}
+	if len(stats.Errors) > 0 {
+		os.Exit(1)
+	}
+}
+
+// importFormat returns the format from the flag or the file extension
+func importFormat(format, file string) (importer.Format, error) {
+	if format == "" {
+		format = strings.TrimPrefix(filepath.Ext(strings.TrimSuffix(file, ".gz")), ".")
+	}
+	switch strings.ToLower(format) {
+	case "json", "jsonl", "ndjson":
+		return importer.JSON, nil
+	case "csv":
+		return importer.CSV, nil
+	case "bson":
+		return importer.BSON, nil
+	}
+	return 0, fmt.Errorf("Unknown import format %q, use -format json, csv or bson", format)
+}
@@ -0,0 +1,355 @@
+package gmgo
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix is the prefix of the environment variables read by LoadEnvConfig
+const EnvPrefix = "GMGO_"
+
+// ConfigError is returned when a configuration value is missing or invalid. Key is the
+// environment variable or the file path of the offending value.
+type ConfigError struct {
+	Key string
+	Err string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("gmgo config %s: %s", e.Key, e.Err)
+}
+
+// fileDbConfig is the representation of a DbConfig entry in YAML and JSON config files
+type fileDbConfig struct {
+	URI           string   `json:"uri" yaml:"uri"`
+	DBName        string   `json:"db" yaml:"db"`
+	Hosts         []string `json:"hosts" yaml:"hosts"`
+	UserName      string   `json:"user" yaml:"user"`
+	Password      string   `json:"password" yaml:"password"`
+	PasswordFile  string   `json:"passwordFile" yaml:"passwordFile"`
+	Mode          int      `json:"mode" yaml:"mode"`
+	DialTimeout   string   `json:"dialTimeout" yaml:"dialTimeout"`
+	SocketTimeout string   `json:"socketTimeout" yaml:"socketTimeout"`
+	SyncTimeout   string   `json:"syncTimeout" yaml:"syncTimeout"`
+	PoolLimit     int      `json:"poolLimit" yaml:"poolLimit"`
+	PoolTimeout   string   `json:"poolTimeout" yaml:"poolTimeout"`
+	MinPoolSize   int      `json:"minPoolSize" yaml:"minPoolSize"`
+	MaxIdleTime   string   `json:"maxIdleTime" yaml:"maxIdleTime"`
+}
+
+// configFile is the root of YAML and JSON config files
+type configFile struct {
+	Databases map[string]fileDbConfig `json:"databases" yaml:"databases"`
+}
+
+// LoadEnvConfig loads the DbConfig for the given alias from the environment variables.
+// Supported variables, where <ALIAS> is the upper cased alias:
+//
+//	GMGO_<ALIAS>_URI             MongoDB connection string
+//	GMGO_<ALIAS>_DB              database name, defaults to the database in the URI
+//	GMGO_<ALIAS>_HOSTS           comma separated host list, used instead of URI
+//	GMGO_<ALIAS>_USER            user name
+//	GMGO_<ALIAS>_PASSWORD        password
+//	GMGO_<ALIAS>_PASSWORD_FILE   file containing the password, e.g. a mounted secret
+//	GMGO_<ALIAS>_MODE            mode
+//	GMGO_<ALIAS>_DIAL_TIMEOUT    dial timeout as a duration, e.g. 10s
+//	GMGO_<ALIAS>_SOCKET_TIMEOUT  socket timeout
+//	GMGO_<ALIAS>_SYNC_TIMEOUT    sync timeout
+//	GMGO_<ALIAS>_POOL_LIMIT      pool limit
+//	GMGO_<ALIAS>_POOL_TIMEOUT    pool timeout
+//	GMGO_<ALIAS>_MIN_POOL_SIZE   min pool size
+//	GMGO_<ALIAS>_MAX_IDLE_TIME   max idle time
+func LoadEnvConfig(alias string) (DbConfig, error) {
+	prefix := EnvPrefix + strings.ToUpper(alias) + "_"
+	key := func(name string) string { return prefix + name }
+
+	cfg := DbConfig{
+		HostURL:  os.Getenv(key("URI")),
+		DBName:   os.Getenv(key("DB")),
+		UserName: os.Getenv(key("USER")),
+		Password: os.Getenv(key("PASSWORD")),
+	}
+	if hosts := os.Getenv(key("HOSTS")); hosts != "" {
+		for _, h := range strings.Split(hosts, ",") {
+			if h = strings.TrimSpace(h); h != "" {
+				cfg.Hosts = append(cfg.Hosts, h)
+			}
+		}
+	}
+
+	var err error
+	if cfg.Mode, err = envInt(key("MODE")); err != nil {
+		return cfg, err
+	}
+	if cfg.PoolLimit, err = envInt(key("POOL_LIMIT")); err != nil {
+		return cfg, err
+	}
+	if cfg.MinPoolSize, err = envInt(key("MIN_POOL_SIZE")); err != nil {
+		return cfg, err
+	}
+	if cfg.DialTimeout, err = parseDuration(key("DIAL_TIMEOUT"), os.Getenv(key("DIAL_TIMEOUT"))); err != nil {
+		return cfg, err
+	}
+	if cfg.SocketTimeout, err = parseDuration(key("SOCKET_TIMEOUT"), os.Getenv(key("SOCKET_TIMEOUT"))); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncTimeout, err = parseDuration(key("SYNC_TIMEOUT"), os.Getenv(key("SYNC_TIMEOUT"))); err != nil {
+		return cfg, err
+	}
+	if cfg.PoolTimeout, err = parseDuration(key("POOL_TIMEOUT"), os.Getenv(key("POOL_TIMEOUT"))); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleTime, err = parseDuration(key("MAX_IDLE_TIME"), os.Getenv(key("MAX_IDLE_TIME"))); err != nil {
+		return cfg, err
+	}
+
+	if err := resolvePassword(&cfg, key("PASSWORD"), key("PASSWORD_FILE"), os.Getenv(key("PASSWORD_FILE"))); err != nil {
+		return cfg, err
+	}
+	return cfg, validateConfig(&cfg, key("URI"), key("DB"))
+}
+
+// LoadEnvConfigs loads the DbConfig of every alias that has a GMGO_<ALIAS>_URI or
+// GMGO_<ALIAS>_HOSTS environment variable. The result is keyed by the lower cased alias.
+func LoadEnvConfigs() (map[string]DbConfig, error) {
+	configs := make(map[string]DbConfig)
+	for _, env := range os.Environ() {
+		name := strings.SplitN(env, "=", 2)[0]
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+
+		var alias string
+		if strings.HasSuffix(name, "_URI") {
+			alias = strings.TrimSuffix(strings.TrimPrefix(name, EnvPrefix), "_URI")
+		} else if strings.HasSuffix(name, "_HOSTS") {
+			alias = strings.TrimSuffix(strings.TrimPrefix(name, EnvPrefix), "_HOSTS")
+		}
+		if alias == "" {
+			continue
+		}
+
+		alias = strings.ToLower(alias)
+		if _, ok := configs[alias]; ok {
+			continue
+		}
+		cfg, err := LoadEnvConfig(alias)
+		if err != nil {
+			return nil, err
+		}
+		configs[alias] = cfg
+	}
+	if err := checkDatabaseNames(configs, func(alias string) string { return EnvPrefix + strings.ToUpper(alias) + "_DB" }); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// LoadConfigFile loads the named DbConfig entries from a YAML (.yaml, .yml) or JSON (.json) file.
+// Durations are written as strings, e.g. "10s". For example:
+//
+//	databases:
+//	  users:
+//	    uri: mongodb://localhost:27017/userdb
+//	    user: app
+//	    passwordFile: /run/secrets/userdb-password
+//	    poolLimit: 100
+//	    socketTimeout: 30s
+func LoadConfigFile(path string) (map[string]DbConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, &ConfigError{Key: path, Err: err.Error()}
+	}
+
+	cf := configFile{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		// unknown fields are rejected, like yaml.UnmarshalStrict does
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&cf)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &cf)
+	default:
+		return nil, &ConfigError{Key: path, Err: "unsupported file type, expected .yaml, .yml or .json"}
+	}
+	if err != nil {
+		return nil, &ConfigError{Key: path, Err: err.Error()}
+	}
+
+	// sorted so that validation errors are reported in a stable order
+	aliases := make([]string, 0, len(cf.Databases))
+	for alias := range cf.Databases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	configs := make(map[string]DbConfig, len(aliases))
+	for _, alias := range aliases {
+		cfg, err := cf.Databases[alias].dbConfig(path + ": databases." + alias + ".")
+		if err != nil {
+			return nil, err
+		}
+		configs[alias] = cfg
+	}
+	if err := checkDatabaseNames(configs, func(alias string) string { return path + ": databases." + alias + ".db" }); err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// SetupAll sets up the connection for each of the given configs. As connections are registered by database
+// name, two aliases of the same database are rejected before any connection is set up.
+func SetupAll(configs map[string]DbConfig) error {
+	if err := checkDatabaseNames(configs, func(alias string) string { return alias }); err != nil {
+		return err
+	}
+	for alias, cfg := range configs {
+		if err := Setup(cfg); err != nil {
+			return fmt.Errorf("gmgo setup %s: %s", alias, err)
+		}
+	}
+	return nil
+}
+
+// checkDatabaseNames returns a ConfigError if two aliases have the same database name, as the second one would
+// replace the connection of the first. key names the offending alias in the error
+func checkDatabaseNames(configs map[string]DbConfig, key func(alias string) string) error {
+	aliases := make([]string, 0, len(configs))
+	for alias := range configs {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+
+	seen := make(map[string]string, len(aliases))
+	for _, alias := range aliases {
+		name := configs[alias].DBName
+		if name == "" {
+			continue
+		}
+		if other, ok := seen[name]; ok {
+			return &ConfigError{Key: key(alias), Err: fmt.Sprintf("database %s is already configured as %s", name, other)}
+		}
+		seen[name] = alias
+	}
+	return nil
+}
+
+// dbConfig converts the file entry to DbConfig, prefix is used to name the offending key in errors
+func (fc fileDbConfig) dbConfig(prefix string) (DbConfig, error) {
+	cfg := DbConfig{
+		HostURL:     fc.URI,
+		DBName:      fc.DBName,
+		Hosts:       fc.Hosts,
+		UserName:    fc.UserName,
+		Password:    fc.Password,
+		Mode:        fc.Mode,
+		PoolLimit:   fc.PoolLimit,
+		MinPoolSize: fc.MinPoolSize,
+	}
+
+	var err error
+	if cfg.DialTimeout, err = parseDuration(prefix+"dialTimeout", fc.DialTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.SocketTimeout, err = parseDuration(prefix+"socketTimeout", fc.SocketTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.SyncTimeout, err = parseDuration(prefix+"syncTimeout", fc.SyncTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.PoolTimeout, err = parseDuration(prefix+"poolTimeout", fc.PoolTimeout); err != nil {
+		return cfg, err
+	}
+	if cfg.MaxIdleTime, err = parseDuration(prefix+"maxIdleTime", fc.MaxIdleTime); err != nil {
+		return cfg, err
+	}
+	if fc.PoolLimit < 0 {
+		return cfg, &ConfigError{Key: prefix + "poolLimit", Err: "must not be negative"}
+	}
+	if fc.MinPoolSize < 0 {
+		return cfg, &ConfigError{Key: prefix + "minPoolSize", Err: "must not be negative"}
+	}
+
+	if err := resolvePassword(&cfg, prefix+"password", prefix+"passwordFile", fc.PasswordFile); err != nil {
+		return cfg, err
+	}
+	return cfg, validateConfig(&cfg, prefix+"uri", prefix+"db")
+}
+
+// resolvePassword reads the password from the secret file if it's set
+func resolvePassword(cfg *DbConfig, passwordKey, fileKey, file string) error {
+	if file == "" {
+		return nil
+	}
+	if cfg.Password != "" {
+		return &ConfigError{Key: fileKey, Err: "cannot be used together with " + passwordKey}
+	}
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return &ConfigError{Key: fileKey, Err: err.Error()}
+	}
+	cfg.Password = strings.TrimRight(string(data), "\r\n")
+	return nil
+}
+
+// validateConfig checks the connection info and fills the database name from the URI if missing
+func validateConfig(cfg *DbConfig, uriKey, dbKey string) error {
+	if cfg.HostURL == "" && len(cfg.Hosts) == 0 {
+		return &ConfigError{Key: uriKey, Err: "missing connection uri or hosts"}
+	}
+	if cfg.HostURL != "" {
+		info, err := mgo.ParseURL(cfg.HostURL)
+		if err != nil {
+			return &ConfigError{Key: uriKey, Err: err.Error()}
+		}
+		if cfg.DBName == "" {
+			cfg.DBName = info.Database
+		}
+	}
+	if cfg.DBName == "" {
+		return &ConfigError{Key: dbKey, Err: "missing database name"}
+	}
+	return nil
+}
+
+func envInt(key string) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Err: fmt.Sprintf("invalid integer %q", v)}
+	}
+	if n < 0 {
+		return 0, &ConfigError{Key: key, Err: "must not be negative"}
+	}
+	return n, nil
+}
+
+func parseDuration(key, v string) (time.Duration, error) {
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, &ConfigError{Key: key, Err: fmt.Sprintf("invalid duration %q", v)}
+	}
+	if d < 0 {
+		return 0, &ConfigError{Key: key, Err: "must not be negative"}
+	}
+	return d, nil
+}
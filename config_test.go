@@ -0,0 +1,110 @@
+package gmgo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadEnvConfig(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gmgo")
+	defer os.RemoveAll(dir)
+	secret := filepath.Join(dir, "password")
+	ioutil.WriteFile(secret, []byte("s3cret\n"), 0600)
+
+	t.Setenv("GMGO_USERS_URI", "mongodb://localhost:27017/userdb")
+	t.Setenv("GMGO_USERS_USER", "app")
+	t.Setenv("GMGO_USERS_PASSWORD_FILE", secret)
+	t.Setenv("GMGO_USERS_POOL_LIMIT", "50")
+	t.Setenv("GMGO_USERS_SOCKET_TIMEOUT", "30s")
+
+	configs, err := LoadEnvConfigs()
+	if err != nil {
+		t.Fatalf("Load failed %s", err)
+	}
+	cfg, ok := configs["users"]
+	if !ok {
+		t.Fatalf("Expected users config, got %+v", configs)
+	}
+	if cfg.DBName != "userdb" || cfg.UserName != "app" || cfg.Password != "s3cret" {
+		t.Errorf("Unexpected config %+v", cfg)
+	}
+	if cfg.PoolLimit != 50 || cfg.SocketTimeout != 30*time.Second {
+		t.Errorf("Unexpected tuning %+v", cfg)
+	}
+
+	t.Setenv("GMGO_USERS_POOL_LIMIT", "many")
+	_, err = LoadEnvConfig("users")
+	if err == nil || !strings.Contains(err.Error(), "GMGO_USERS_POOL_LIMIT") {
+		t.Errorf("Expected error naming GMGO_USERS_POOL_LIMIT, got %v", err)
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gmgo")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gmgo.yaml")
+	ioutil.WriteFile(path, []byte(`
+databases:
+  users:
+    uri: mongodb://localhost:27017/userdb
+    dialTimeout: 5s
+  events:
+    hosts: [db1:27017, db2:27017]
+    db: events
+`), 0600)
+
+	configs, err := LoadConfigFile(path)
+	if err != nil {
+		t.Fatalf("Load failed %s", err)
+	}
+	if configs["users"].DBName != "userdb" || configs["users"].DialTimeout != 5*time.Second {
+		t.Errorf("Unexpected users config %+v", configs["users"])
+	}
+	if len(configs["events"].Hosts) != 2 {
+		t.Errorf("Unexpected events config %+v", configs["events"])
+	}
+
+	path = filepath.Join(dir, "gmgo.json")
+	ioutil.WriteFile(path, []byte(`{"databases": {"users": {"uri": "localhost:27017", "syncTimeout": "soon"}}}`), 0600)
+	_, err = LoadConfigFile(path)
+	if err == nil || !strings.Contains(err.Error(), "databases.users.syncTimeout") {
+		t.Errorf("Expected error naming databases.users.syncTimeout, got %v", err)
+	}
+
+	ioutil.WriteFile(path, []byte(`{"databases": {"users": {"uri": "localhost:27017", "poolLimt": 10}}}`), 0600)
+	if _, err = LoadConfigFile(path); err == nil || !strings.Contains(err.Error(), "poolLimt") {
+		t.Errorf("Expected error for the unknown JSON field, got %v", err)
+	}
+}
+
+func TestDuplicateDatabaseNames(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "gmgo")
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "gmgo.yaml")
+	ioutil.WriteFile(path, []byte(`
+databases:
+  users:
+    uri: mongodb://localhost:27017/userdb
+  accounts:
+    hosts: [localhost:27017]
+    db: userdb
+`), 0600)
+	_, err := LoadConfigFile(path)
+	if err == nil || !strings.Contains(err.Error(), "databases.users.db") || !strings.Contains(err.Error(), "accounts") {
+		t.Errorf("Expected error naming both aliases, got %v", err)
+	}
+
+	err = SetupAll(map[string]DbConfig{
+		"users":    {HostURL: "mongodb://localhost:27017/userdb", DBName: "userdb"},
+		"accounts": {HostURL: "mongodb://localhost:27017/userdb", DBName: "userdb"},
+	})
+	if _, ok := err.(*ConfigError); !ok {
+		t.Errorf("Expected ConfigError before connecting, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package gmgo
+
+import (
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ExplainPlan is the parsed query plan and execution statistics returned by Explain
+type ExplainPlan struct {
+	// WinningStage is the top stage of the winning plan, e.g. FETCH, SORT or COLLSCAN
+	WinningStage string
+	// Stages lists the stages of the winning plan from the top to the leaves, e.g. [FETCH IXSCAN]
+	Stages []string
+	// IndexName is the name of the index used, empty if the plan doesn't use an index
+	IndexName string
+	// IndexKeys is the key pattern of the index used
+	IndexKeys bson.M
+	// CollScan is true if the plan scans the whole collection
+	CollScan bool
+
+	KeysExamined  int
+	DocsExamined  int
+	DocsReturned  int
+	ExecutionTime time.Duration
+
+	// Raw is the complete explain output
+	Raw bson.M
+}
+
+// Explain runs the query with the explain option and returns the parsed plan of the winning query plan
+// along with the number of keys and documents examined vs returned. The sort, hint, limit and other
+// query options of the IteratorConfig are applied to the explained query.
+//
+// For example:
+//
+//	plan, err := session.Explain(gmgo.Q{"state": "CA"}, new(user), gmgo.IteratorConfig{SortBy: []string{"-createdDate"}})
+//	if err == nil && plan.CollScan {
+//		log.Printf("user query scans %d documents to return %d", plan.DocsExamined, plan.DocsReturned)
+//	}
+func (s *DbSession) Explain(query Q, document Document, cfg IteratorConfig) (*ExplainPlan, error) {
+	return s.explain(document.CollectionName(), query, cfg)
+}
+
+// explain explains the find query on the collection
+func (s *DbSession) explain(collection string, query Q, cfg IteratorConfig) (*ExplainPlan, error) {
+	raw := bson.M{}
+	var run func() error
+	if cfg.requiresFindCommand() {
+		// same as the iterator, the options mgo.Query doesn't support need the find command
+		cmd := cfg.explainCommand(collection, query)
+		run = func() error { return s.collection(collection).Database.Run(cmd, &raw) }
+	} else {
+		q := cfg.apply(s.findQueryByCollectionName(collection, query))
+		run = func() error { return q.Explain(raw) }
+	}
+	if err := s.retry(false, run); err != nil {
+		return nil, err
+	}
+	return parseExplain(raw), nil
+}
+
+// explainCommand builds the explain command of the find command for the iterator config
+func (cfg IteratorConfig) explainCommand(collection string, filter Q) bson.D {
+	return bson.D{
+		{Name: "explain", Value: cfg.findCommand(collection, filter)},
+		{Name: "verbosity", Value: "executionStats"},
+	}
+}
+
+// parseExplain parses the explain output of MongoDB 3.0 and later
+func parseExplain(raw bson.M) *ExplainPlan {
+	plan := &ExplainPlan{Raw: raw}
+
+	if qp, ok := raw["queryPlanner"].(bson.M); ok {
+		if wp, ok := qp["winningPlan"].(bson.M); ok {
+			plan.WinningStage, _ = wp["stage"].(string)
+			plan.walkStages(wp)
+		}
+	}
+
+	if stats, ok := raw["executionStats"].(bson.M); ok {
+		plan.DocsReturned = toInt(stats["nReturned"])
+		plan.KeysExamined = toInt(stats["totalKeysExamined"])
+		plan.DocsExamined = toInt(stats["totalDocsExamined"])
+		plan.ExecutionTime = time.Duration(toInt(stats["executionTimeMillis"])) * time.Millisecond
+	}
+	return plan
+}
+
+// walkStages collects the stages of the plan tree, including the plans of each shard
+func (plan *ExplainPlan) walkStages(stage bson.M) {
+	name, _ := stage["stage"].(string)
+	if name != "" {
+		plan.Stages = append(plan.Stages, name)
+	}
+	switch name {
+	case "COLLSCAN":
+		plan.CollScan = true
+	case "IXSCAN":
+		if plan.IndexName == "" {
+			plan.IndexName, _ = stage["indexName"].(string)
+			plan.IndexKeys, _ = stage["keyPattern"].(bson.M)
+		}
+	}
+
+	if input, ok := stage["inputStage"].(bson.M); ok {
+		plan.walkStages(input)
+	}
+	for _, key := range []string{"inputStages", "shards"} {
+		list, _ := stage[key].([]interface{})
+		for _, item := range list {
+			sub, ok := item.(bson.M)
+			if !ok {
+				continue
+			}
+			if wp, ok := sub["winningPlan"].(bson.M); ok {
+				sub = wp
+			}
+			plan.walkStages(sub)
+		}
+	}
+}
+
+func toInt(v interface{}) int {
+	switch n := v.(type) {
+	case int:
+		return n
+	case int64:
+		return int(n)
+	case float64:
+		return int(n)
+	}
+	return 0
+}
@@ -0,0 +1,80 @@
+package gmgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestParseExplain(t *testing.T) {
+	// decoded the same way as the server response, nested documents as bson.M
+	data, _ := bson.Marshal(bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"stage": "FETCH",
+				"inputStage": bson.M{
+					"stage":      "IXSCAN",
+					"indexName":  "state_1",
+					"keyPattern": bson.M{"state": 1},
+				},
+			},
+		},
+		"executionStats": bson.M{
+			"nReturned":           12,
+			"totalKeysExamined":   12,
+			"totalDocsExamined":   12,
+			"executionTimeMillis": 3,
+		},
+	})
+	raw := bson.M{}
+	bson.Unmarshal(data, raw)
+
+	plan := parseExplain(raw)
+	if plan.WinningStage != "FETCH" || len(plan.Stages) != 2 || plan.Stages[1] != "IXSCAN" {
+		t.Errorf("Unexpected stages %s %v", plan.WinningStage, plan.Stages)
+	}
+	if plan.IndexName != "state_1" || plan.IndexKeys["state"] != 1 || plan.CollScan {
+		t.Errorf("Unexpected index %s %v", plan.IndexName, plan.IndexKeys)
+	}
+	if plan.DocsReturned != 12 || plan.DocsExamined != 12 || plan.KeysExamined != 12 || plan.ExecutionTime != 3*time.Millisecond {
+		t.Errorf("Unexpected stats %+v", plan)
+	}
+}
+
+func TestParseExplainShardedCollScan(t *testing.T) {
+	data, _ := bson.Marshal(bson.M{
+		"queryPlanner": bson.M{
+			"winningPlan": bson.M{
+				"stage": "SHARD_MERGE",
+				"shards": []bson.M{
+					{"winningPlan": bson.M{"stage": "COLLSCAN"}},
+					{"winningPlan": bson.M{"stage": "COLLSCAN"}},
+				},
+			},
+		},
+		"executionStats": bson.M{"nReturned": 1, "totalDocsExamined": int64(5000)},
+	})
+	raw := bson.M{}
+	bson.Unmarshal(data, raw)
+
+	plan := parseExplain(raw)
+	if !plan.CollScan || plan.IndexName != "" || len(plan.Stages) != 3 {
+		t.Errorf("Expected collection scan on all shards, got %v", plan.Stages)
+	}
+	if plan.DocsExamined != 5000 || plan.DocsReturned != 1 {
+		t.Errorf("Unexpected stats %+v", plan)
+	}
+}
+
+func TestExplainCommand(t *testing.T) {
+	cfg := IteratorConfig{HintIndex: "state_1", NoCursorTimeout: true, Limit: 5}
+	cmd := cfg.explainCommand("user", Q{"state": "CA"})
+	if len(cmd) != 2 || cmd[0].Name != "explain" || cmd[1].Value != "executionStats" {
+		t.Fatalf("Unexpected explain command %v", cmd)
+	}
+	if find := cmd[0].Value.(bson.D); !reflect.DeepEqual(find, cfg.findCommand("user", Q{"state": "CA"})) {
+		t.Errorf("Expected the find command of the iterator, got %v", find)
+	}
+}
@@ -0,0 +1,296 @@
+// Package exporter streams the documents of a MongoDB query through gmgo.DocumentIterator into
+// JSON Lines, CSV or mongodump compatible BSON files.
+//
+// For example:
+//
+//	session := db.Session()
+//	defer session.Close()
+//
+//	n, err := exporter.ExportFile("users.csv.gz", session, "user", gmgo.Q{"state": "CA"}, exporter.Options{
+//		Format: exporter.CSV,
+//		Fields: []string{"_id", "fullName", "address.city"},
+//	})
+package exporter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/narup/gmgo"
+	"github.com/narup/gmgo/extjson"
+)
+
+// Format is the output file format
+type Format int
+
+const (
+	// JSONLines writes one Extended JSON document per line
+	JSONLines Format = iota
+	// CSV writes the chosen fields, with nested documents flattened using dotted paths
+	CSV
+	// BSON writes the raw documents one after the other, same as the .bson files of mongodump
+	BSON
+)
+
+// Options configures the export
+type Options struct {
+	Format Format
+	// Mode is the Extended JSON mode used by JSONLines
+	Mode extjson.Mode
+	// Fields are the CSV columns as dotted paths, e.g. "address.city" or "tags.0". When empty, the columns are the
+	// flattened fields of the first document.
+	Fields []string
+	// Gzip compresses the output. ExportFile also compresses when the path ends with .gz
+	Gzip bool
+	// Iterator configures the query, e.g. sort, limit or projection
+	Iterator gmgo.IteratorConfig
+	// Progress is called with the number of documents exported every ProgressInterval documents and at the end
+	Progress func(count int64)
+	// ProgressInterval defaults to 1000
+	ProgressInterval int64
+}
+
+// documentWriter writes the documents in a specific format
+type documentWriter interface {
+	write(raw bson.Raw) error
+	flush() error
+}
+
+// ExportFile exports the documents matching the query to the file at the given path and returns the number of
+// documents exported
+func ExportFile(path string, session *gmgo.DbSession, collection string, query gmgo.Q, opts Options) (int64, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+
+	if strings.HasSuffix(path, ".gz") {
+		opts.Gzip = true
+	}
+	n, err := Export(f, session, collection, query, opts)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// Export writes the documents matching the query to w and returns the number of documents exported
+func Export(w io.Writer, session *gmgo.DbSession, collection string, query gmgo.Q, opts Options) (int64, error) {
+	if opts.ProgressInterval <= 0 {
+		opts.ProgressInterval = 1000
+	}
+
+	var gz *gzip.Writer
+	if opts.Gzip {
+		gz = gzip.NewWriter(w)
+		w = gz
+	}
+	bw := bufio.NewWriter(w)
+
+	var dw documentWriter
+	switch opts.Format {
+	case JSONLines:
+		dw = &jsonLinesWriter{w: bw, mode: opts.Mode}
+	case CSV:
+		dw = &csvWriter{w: csv.NewWriter(bw), fields: opts.Fields}
+	case BSON:
+		dw = &bsonWriter{w: bw}
+	default:
+		return 0, fmt.Errorf("exporter: unknown format %d", opts.Format)
+	}
+
+	itr := session.DocumentIterator(query, collection)
+	itr.Load(opts.Iterator)
+	defer itr.Close()
+
+	var count int64
+	raw := bson.Raw{}
+	for itr.FetchNext(&raw) {
+		if err := dw.write(raw); err != nil {
+			return count, err
+		}
+		count++
+		if opts.Progress != nil && count%opts.ProgressInterval == 0 {
+			opts.Progress(count)
+		}
+	}
+	if err := itr.Error(); err != nil {
+		return count, err
+	}
+
+	if err := dw.flush(); err != nil {
+		return count, err
+	}
+	if err := bw.Flush(); err != nil {
+		return count, err
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			return count, err
+		}
+	}
+	if opts.Progress != nil {
+		opts.Progress(count)
+	}
+	return count, nil
+}
+
+type jsonLinesWriter struct {
+	w    *bufio.Writer
+	mode extjson.Mode
+}
+
+func (jw *jsonLinesWriter) write(raw bson.Raw) error {
+	data, err := extjson.MarshalRaw(raw, jw.mode)
+	if err != nil {
+		return err
+	}
+	jw.w.Write(data)
+	return jw.w.WriteByte('\n')
+}
+
+func (jw *jsonLinesWriter) flush() error {
+	return nil
+}
+
+type bsonWriter struct {
+	w *bufio.Writer
+}
+
+func (bw *bsonWriter) write(raw bson.Raw) error {
+	if raw.Kind != 0x03 {
+		return errors.New("exporter: document is not a BSON document")
+	}
+	_, err := bw.w.Write(raw.Data)
+	return err
+}
+
+func (bw *bsonWriter) flush() error {
+	return nil
+}
+
+type csvWriter struct {
+	w       *csv.Writer
+	fields  []string
+	started bool
+}
+
+func (cw *csvWriter) write(raw bson.Raw) error {
+	doc := bson.D{}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return err
+	}
+
+	if !cw.started {
+		if len(cw.fields) == 0 {
+			cw.fields = Flatten(doc)
+		}
+		if err := cw.w.Write(cw.fields); err != nil {
+			return err
+		}
+		cw.started = true
+	}
+
+	record := make([]string, len(cw.fields))
+	for i, f := range cw.fields {
+		v, ok := Lookup(doc, f)
+		if !ok {
+			continue
+		}
+		s, err := FormatValue(v)
+		if err != nil {
+			return fmt.Errorf("exporter: field %s: %s", f, err)
+		}
+		record[i] = s
+	}
+	return cw.w.Write(record)
+}
+
+func (cw *csvWriter) flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// Flatten returns the dotted paths of all the leaf fields of the document. Arrays are not expanded.
+func Flatten(doc bson.D) []string {
+	var paths []string
+	for _, e := range doc {
+		if sub, ok := e.Value.(bson.D); ok {
+			for _, p := range Flatten(sub) {
+				paths = append(paths, e.Name+"."+p)
+			}
+			continue
+		}
+		paths = append(paths, e.Name)
+	}
+	return paths
+}
+
+// Lookup returns the value at the dotted path, where numeric path elements index into arrays
+func Lookup(doc bson.D, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case bson.D:
+			found := false
+			for _, e := range v {
+				if e.Name == name {
+					value, found = e.Value, true
+					break
+				}
+			}
+			if !found {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// FormatValue formats the value for a CSV cell. Embedded documents and arrays are written as relaxed Extended JSON
+func FormatValue(v interface{}) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case bool:
+		return strconv.FormatBool(val), nil
+	case int:
+		return strconv.Itoa(val), nil
+	case int64:
+		return strconv.FormatInt(val, 10), nil
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64), nil
+	case time.Time:
+		return val.UTC().Format(time.RFC3339Nano), nil
+	case bson.ObjectId:
+		return val.Hex(), nil
+	case bson.Decimal128:
+		return val.String(), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val), nil
+	case bson.Binary:
+		return base64.StdEncoding.EncodeToString(val.Data), nil
+	}
+	data, err := extjson.Marshal(v, extjson.Relaxed)
+	return string(data), err
+}
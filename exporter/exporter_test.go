@@ -0,0 +1,64 @@
+package exporter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"testing"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/narup/gmgo/extjson"
+)
+
+func rawDocument(t *testing.T, doc bson.D) bson.Raw {
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Marshal failed %s", err)
+	}
+	return bson.Raw{Kind: 0x03, Data: data}
+}
+
+func TestCSVWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	cw := &csvWriter{w: csv.NewWriter(buf)}
+
+	cw.write(rawDocument(t, bson.D{
+		{Name: "name", Value: "Puran"},
+		{Name: "address", Value: bson.D{{Name: "city", Value: "SF"}, {Name: "zip", Value: "94105"}}},
+		{Name: "tags", Value: []interface{}{"a", "b"}},
+	}))
+	cw.write(rawDocument(t, bson.D{{Name: "name", Value: "Ana, Jr."}}))
+	if err := cw.flush(); err != nil {
+		t.Fatalf("Flush failed %s", err)
+	}
+
+	expected := "name,address.city,address.zip,tags\nPuran,SF,94105,\"[\"\"a\"\",\"\"b\"\"]\"\n\"Ana, Jr.\",,,\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected CSV\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestJSONLinesWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	bw := bufio.NewWriter(buf)
+	jw := &jsonLinesWriter{w: bw, mode: extjson.Canonical}
+
+	jw.write(rawDocument(t, bson.D{{Name: "n", Value: 1}}))
+	jw.write(rawDocument(t, bson.D{{Name: "n", Value: 2}}))
+	bw.Flush()
+
+	expected := "{\"n\":{\"$numberInt\":\"1\"}}\n{\"n\":{\"$numberInt\":\"2\"}}\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected JSON lines\n%s\nexpected\n%s", buf.String(), expected)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	doc := bson.D{{Name: "tags", Value: []interface{}{"a", bson.D{{Name: "k", Value: "v"}}}}}
+	if v, ok := Lookup(doc, "tags.1.k"); !ok || v != "v" {
+		t.Errorf("Expected v, got %v", v)
+	}
+	if _, ok := Lookup(doc, "tags.5"); ok {
+		t.Error("Expected missing array index")
+	}
+}
@@ -0,0 +1,314 @@
+package extjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Decoder reads a stream of Extended JSON documents, either one after the other (JSON Lines) or as the
+// elements of a top level array. Canonical and relaxed values are both accepted.
+type Decoder struct {
+	dec     *json.Decoder
+	inArray bool
+	started bool
+}
+
+// NewDecoder creates the decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	return &Decoder{dec: dec}
+}
+
+// Decode returns the next document, or io.EOF when there are no more documents
+func (d *Decoder) Decode() (bson.D, error) {
+	if !d.started {
+		d.started = true
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch tok {
+		case json.Delim('['):
+			d.inArray = true
+		case json.Delim('{'):
+			return d.document()
+		default:
+			return nil, fmt.Errorf("extjson: expected document or array, found %v", tok)
+		}
+	}
+
+	if d.inArray && !d.dec.More() {
+		if _, err := d.dec.Token(); err != nil {
+			return nil, err
+		}
+		d.inArray = false
+	}
+	if !d.inArray && !d.dec.More() {
+		return nil, io.EOF
+	}
+
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("extjson: expected document, found %v", tok)
+	}
+	return d.document()
+}
+
+// InputOffset returns the offset of the decoder in the input, useful to locate errors
+func (d *Decoder) InputOffset() int64 {
+	return d.dec.InputOffset()
+}
+
+// Unmarshal decodes the Extended JSON document
+func Unmarshal(data []byte) (bson.D, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	d := &Decoder{dec: dec, started: true}
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	if tok != json.Delim('{') {
+		return nil, fmt.Errorf("extjson: expected document, found %v", tok)
+	}
+	return d.document()
+}
+
+// document reads the rest of the document after the opening brace
+func (d *Decoder) document() (bson.D, error) {
+	doc := bson.D{}
+	for d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		name, ok := tok.(string)
+		if !ok {
+			return nil, fmt.Errorf("extjson: expected field name, found %v", tok)
+		}
+		value, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		doc = append(doc, bson.DocElem{Name: name, Value: value})
+	}
+	if _, err := d.dec.Token(); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// value reads the next value, converting the Extended JSON type wrappers
+func (d *Decoder) value() (interface{}, error) {
+	tok, err := d.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	switch v := tok.(type) {
+	case json.Delim:
+		if v == '[' {
+			arr := []interface{}{}
+			for d.dec.More() {
+				e, err := d.value()
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, e)
+			}
+			_, err := d.dec.Token()
+			return arr, err
+		}
+		doc, err := d.document()
+		if err != nil {
+			return nil, err
+		}
+		return convert(doc)
+	case json.Number:
+		return number(v)
+	default:
+		// string, bool or nil
+		return v, nil
+	}
+}
+
+// number converts the relaxed number to int (int32 range), int64 or float64
+func number(n json.Number) (interface{}, error) {
+	if i, err := strconv.ParseInt(string(n), 10, 64); err == nil {
+		if i >= math.MinInt32 && i <= math.MaxInt32 {
+			return int(i), nil
+		}
+		return i, nil
+	}
+	return strconv.ParseFloat(string(n), 64)
+}
+
+// convert returns the BSON value of the type wrapper document, or the document itself
+func convert(doc bson.D) (interface{}, error) {
+	if len(doc) == 0 || len(doc[0].Name) == 0 || doc[0].Name[0] != '$' {
+		return doc, nil
+	}
+
+	first := doc[0].Value
+	switch doc[0].Name {
+	case "$oid":
+		s, ok := first.(string)
+		if !ok || !bson.IsObjectIdHex(s) {
+			return nil, fmt.Errorf("extjson: invalid $oid %v", first)
+		}
+		return bson.ObjectIdHex(s), nil
+	case "$numberInt":
+		s, _ := first.(string)
+		i, err := strconv.ParseInt(s, 10, 32)
+		return int(i), err
+	case "$numberLong":
+		s, _ := first.(string)
+		return strconv.ParseInt(s, 10, 64)
+	case "$numberDouble":
+		s, _ := first.(string)
+		switch s {
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		case "NaN":
+			return math.NaN(), nil
+		}
+		return strconv.ParseFloat(s, 64)
+	case "$numberDecimal":
+		s, _ := first.(string)
+		return bson.ParseDecimal128(s)
+	case "$date":
+		return date(first)
+	case "$binary":
+		return binary(doc)
+	case "$regularExpression":
+		sub, _ := first.(bson.D)
+		re := bson.RegEx{}
+		for _, e := range sub {
+			switch e.Name {
+			case "pattern":
+				re.Pattern, _ = e.Value.(string)
+			case "options":
+				re.Options, _ = e.Value.(string)
+			}
+		}
+		return re, nil
+	case "$timestamp":
+		sub, _ := first.(bson.D)
+		var t, i int64
+		for _, e := range sub {
+			n, _ := toInt64(e.Value)
+			if e.Name == "t" {
+				t = n
+			} else if e.Name == "i" {
+				i = n
+			}
+		}
+		return bson.MongoTimestamp(t<<32 | int64(uint32(i))), nil
+	case "$symbol":
+		s, _ := first.(string)
+		return bson.Symbol(s), nil
+	case "$code":
+		s, _ := first.(string)
+		js := bson.JavaScript{Code: s}
+		if len(doc) > 1 && doc[1].Name == "$scope" {
+			js.Scope = doc[1].Value
+		}
+		return js, nil
+	case "$minKey":
+		return bson.MinKey, nil
+	case "$maxKey":
+		return bson.MaxKey, nil
+	case "$undefined":
+		return bson.Undefined, nil
+	case "$dbPointer":
+		sub, _ := first.(bson.D)
+		p := bson.DBPointer{}
+		for _, e := range sub {
+			switch e.Name {
+			case "$ref":
+				p.Namespace, _ = e.Value.(string)
+			case "$id":
+				p.Id, _ = e.Value.(bson.ObjectId)
+			}
+		}
+		return p, nil
+	}
+	// not a type wrapper, e.g. a query operator
+	return doc, nil
+}
+
+// date converts the relaxed ISO-8601 string, canonical $numberLong or legacy millisecond number
+func date(v interface{}) (interface{}, error) {
+	switch d := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, d)
+	case int64:
+		return time.Unix(0, d*int64(time.Millisecond)).UTC(), nil
+	case int:
+		return time.Unix(0, int64(d)*int64(time.Millisecond)).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(d)*int64(time.Millisecond)).UTC(), nil
+	}
+	return nil, fmt.Errorf("extjson: invalid $date %v", v)
+}
+
+// binary converts the canonical {"$binary": {"base64": ..., "subType": ...}} or the legacy
+// {"$binary": ..., "$type": ...} representation
+func binary(doc bson.D) (interface{}, error) {
+	var data, subType string
+	if sub, ok := doc[0].Value.(bson.D); ok {
+		for _, e := range sub {
+			switch e.Name {
+			case "base64":
+				data, _ = e.Value.(string)
+			case "subType":
+				subType, _ = e.Value.(string)
+			}
+		}
+	} else {
+		data, _ = doc[0].Value.(string)
+		if len(doc) > 1 && doc[1].Name == "$type" {
+			subType, _ = doc[1].Value.(string)
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	kind := []byte{0}
+	if subType != "" {
+		if kind, err = hex.DecodeString(fmt.Sprintf("%02s", subType)); err != nil || len(kind) != 1 {
+			return nil, errors.New("extjson: invalid $binary subType " + subType)
+		}
+	}
+	return bson.Binary{Kind: kind[0], Data: b}, nil
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	case float64:
+		return int64(n), true
+	}
+	return 0, false
+}
@@ -0,0 +1,199 @@
+// Package extjson encodes BSON documents as MongoDB Extended JSON v2, in canonical or relaxed mode.
+package extjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Mode is the Extended JSON output mode
+type Mode int
+
+const (
+	// Relaxed mode uses plain JSON numbers and ISO-8601 dates where it doesn't lose type information
+	// that matters to most readers. It's the format of choice for analysts.
+	Relaxed Mode = iota
+	// Canonical mode preserves the type of every value, so the document can be restored exactly
+	Canonical
+)
+
+// Marshal encodes the value, usually a bson.D document, as Extended JSON
+func Marshal(v interface{}, mode Mode) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := encode(buf, v, mode); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// MarshalRaw decodes the raw BSON document and encodes it as Extended JSON, preserving the field order
+func MarshalRaw(raw bson.Raw, mode Mode) ([]byte, error) {
+	doc := bson.D{}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return nil, err
+	}
+	return Marshal(doc, mode)
+}
+
+func encode(buf *bytes.Buffer, v interface{}, mode Mode) error {
+	switch val := v.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case string:
+		writeString(buf, val)
+	case int:
+		// mgo decodes BSON int32 values as int
+		if mode == Canonical {
+			fmt.Fprintf(buf, `{"$numberInt":"%d"}`, val)
+		} else {
+			buf.WriteString(strconv.Itoa(val))
+		}
+	case int32:
+		return encode(buf, int(val), mode)
+	case int64:
+		if mode == Canonical {
+			fmt.Fprintf(buf, `{"$numberLong":"%d"}`, val)
+		} else {
+			buf.WriteString(strconv.FormatInt(val, 10))
+		}
+	case float64:
+		encodeDouble(buf, val, mode)
+	case float32:
+		encodeDouble(buf, float64(val), mode)
+	case time.Time:
+		encodeDate(buf, val, mode)
+	case bson.ObjectId:
+		fmt.Fprintf(buf, `{"$oid":"%s"}`, val.Hex())
+	case bson.D:
+		buf.WriteByte('{')
+		for i, e := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeString(buf, e.Name)
+			buf.WriteByte(':')
+			if err := encode(buf, e.Value, mode); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case bson.M:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		doc := make(bson.D, len(keys))
+		for i, k := range keys {
+			doc[i] = bson.DocElem{Name: k, Value: val[k]}
+		}
+		return encode(buf, doc, mode)
+	case map[string]interface{}:
+		return encode(buf, bson.M(val), mode)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := encode(buf, e, mode); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case []byte:
+		return encode(buf, bson.Binary{Kind: 0, Data: val}, mode)
+	case bson.Binary:
+		fmt.Fprintf(buf, `{"$binary":{"base64":"%s","subType":"%02x"}}`, base64.StdEncoding.EncodeToString(val.Data), val.Kind)
+	case bson.RegEx:
+		buf.WriteString(`{"$regularExpression":{"pattern":`)
+		writeString(buf, val.Pattern)
+		buf.WriteString(`,"options":`)
+		writeString(buf, val.Options)
+		buf.WriteString("}}")
+	case bson.MongoTimestamp:
+		fmt.Fprintf(buf, `{"$timestamp":{"t":%d,"i":%d}}`, uint64(val)>>32, uint32(val))
+	case bson.Decimal128:
+		fmt.Fprintf(buf, `{"$numberDecimal":"%s"}`, val.String())
+	case bson.Symbol:
+		buf.WriteString(`{"$symbol":`)
+		writeString(buf, string(val))
+		buf.WriteByte('}')
+	case bson.JavaScript:
+		buf.WriteString(`{"$code":`)
+		writeString(buf, val.Code)
+		if val.Scope != nil {
+			buf.WriteString(`,"$scope":`)
+			if err := encode(buf, val.Scope, mode); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case bson.DBPointer:
+		buf.WriteString(`{"$dbPointer":{"$ref":`)
+		writeString(buf, val.Namespace)
+		fmt.Fprintf(buf, `,"$id":{"$oid":"%s"}}}`, val.Id.Hex())
+	default:
+		switch v {
+		case bson.MinKey:
+			buf.WriteString(`{"$minKey":1}`)
+		case bson.MaxKey:
+			buf.WriteString(`{"$maxKey":1}`)
+		case bson.Undefined:
+			buf.WriteString(`{"$undefined":true}`)
+		default:
+			return fmt.Errorf("extjson: unsupported type %T", v)
+		}
+	}
+	return nil
+}
+
+func encodeDouble(buf *bytes.Buffer, f float64, mode Mode) {
+	var s string
+	switch {
+	case math.IsNaN(f):
+		s = "NaN"
+	case math.IsInf(f, 1):
+		s = "Infinity"
+	case math.IsInf(f, -1):
+		s = "-Infinity"
+	default:
+		s = strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		if mode == Relaxed {
+			buf.WriteString(s)
+			return
+		}
+	}
+	fmt.Fprintf(buf, `{"$numberDouble":"%s"}`, s)
+}
+
+func encodeDate(buf *bytes.Buffer, t time.Time, mode Mode) {
+	if mode == Relaxed && t.Year() >= 1970 && t.Year() <= 9999 {
+		fmt.Fprintf(buf, `{"$date":"%s"}`, t.UTC().Format("2006-01-02T15:04:05.000Z07:00"))
+		return
+	}
+	ms := t.Unix()*1000 + int64(t.Nanosecond()/1e6)
+	fmt.Fprintf(buf, `{"$date":{"$numberLong":"%d"}}`, ms)
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode appends a new line
+	buf.Truncate(buf.Len() - 1)
+}
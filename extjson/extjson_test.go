@@ -0,0 +1,98 @@
+package extjson
+
+import (
+	"io"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestMarshal(t *testing.T) {
+	id := bson.ObjectIdHex("5713f1b0e4b067fc28d6fbaa")
+	date := time.Date(2018, 5, 1, 10, 30, 0, 0, time.UTC)
+	doc := bson.D{
+		{Name: "_id", Value: id},
+		{Name: "count", Value: 3},
+		{Name: "total", Value: int64(42)},
+		{Name: "ratio", Value: 1.0},
+		{Name: "created", Value: date},
+		{Name: "tags", Value: []interface{}{"a", nil, true}},
+		{Name: "nested", Value: bson.D{{Name: "nan", Value: math.NaN()}}},
+	}
+
+	relaxed, err := Marshal(doc, Relaxed)
+	if err != nil {
+		t.Fatalf("Marshal failed %s", err)
+	}
+	expected := `{"_id":{"$oid":"5713f1b0e4b067fc28d6fbaa"},"count":3,"total":42,"ratio":1.0,` +
+		`"created":{"$date":"2018-05-01T10:30:00.000Z"},"tags":["a",null,true],"nested":{"nan":{"$numberDouble":"NaN"}}}`
+	if string(relaxed) != expected {
+		t.Errorf("Unexpected relaxed output\n%s\nexpected\n%s", relaxed, expected)
+	}
+
+	canonical, err := Marshal(doc, Canonical)
+	if err != nil {
+		t.Fatalf("Marshal failed %s", err)
+	}
+	expected = `{"_id":{"$oid":"5713f1b0e4b067fc28d6fbaa"},"count":{"$numberInt":"3"},"total":{"$numberLong":"42"},` +
+		`"ratio":{"$numberDouble":"1.0"},"created":{"$date":{"$numberLong":"1525170600000"}},"tags":["a",null,true],` +
+		`"nested":{"nan":{"$numberDouble":"NaN"}}}`
+	if string(canonical) != expected {
+		t.Errorf("Unexpected canonical output\n%s\nexpected\n%s", canonical, expected)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	doc := bson.D{
+		{Name: "_id", Value: bson.ObjectIdHex("5713f1b0e4b067fc28d6fbaa")},
+		{Name: "count", Value: 3},
+		{Name: "total", Value: int64(1) << 40},
+		{Name: "ratio", Value: 0.25},
+		{Name: "created", Value: time.Date(1960, 5, 1, 10, 30, 0, 0, time.UTC)},
+		{Name: "data", Value: bson.Binary{Kind: 4, Data: []byte{1, 2, 3}}},
+		{Name: "ts", Value: bson.MongoTimestamp(5<<32 | 7)},
+		{Name: "re", Value: bson.RegEx{Pattern: "^a", Options: "i"}},
+		{Name: "list", Value: []interface{}{bson.D{{Name: "a", Value: "b"}}}},
+	}
+
+	for _, mode := range []Mode{Canonical, Relaxed} {
+		data, err := Marshal(doc, mode)
+		if err != nil {
+			t.Fatalf("Marshal failed %s", err)
+		}
+		decoded, err := Unmarshal(data)
+		if err != nil {
+			t.Fatalf("Unmarshal of %s failed %s", data, err)
+		}
+		if !reflect.DeepEqual(decoded, doc) {
+			t.Errorf("Round trip mismatch in mode %d\n%#v\nexpected\n%#v", mode, decoded, doc)
+		}
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	for _, input := range []string{
+		"{\"n\":1}\n{\"n\":2}\n",
+		"[{\"n\":1},\n{\"n\":2}]",
+	} {
+		dec := NewDecoder(strings.NewReader(input))
+		count := 0
+		for {
+			_, err := dec.Decode()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Decode of %q failed %s", input, err)
+			}
+			count++
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 documents from %q, got %d", input, count)
+		}
+	}
+}
@@ -0,0 +1,383 @@
+package gmgo
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrContentNotFound is returned by FileStore.Open when there's no content under the key
+var ErrContentNotFound = errors.New("file content not found")
+
+// errWriteAborted is the error of an upload discarded with Abort
+var errWriteAborted = errors.New("write aborted")
+
+// defaultChunkSize is the GridFS chunk size used by mgo
+const defaultChunkSize = 255 * 1024
+
+// FileStore stores the content of GridFS files outside of the GridFS chunks, while the files document stays in
+// the bucket as a pointer to the content. Stores are registered by name with RegisterFileStore and selected for
+// new files with BucketConfig.Store. The content of a file is stored under the key <bucket prefix>/<file id>.
+type FileStore interface {
+	// Put stores the content read from r until EOF under the key. Nothing must be stored if reading r fails
+	Put(key string, r io.Reader) error
+	// Open returns the content stored under the key, or ErrContentNotFound
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes the content stored under the key. Deleting a missing key is not an error
+	Delete(key string) error
+}
+
+// fileStores holds the file stores by name
+var fileStores = make(map[string]FileStore)
+
+// RegisterFileStore registers the file store with the given name. Like Setup, it's meant to be called during
+// initialization, and a store must stay registered while files reference it.
+//
+// For example:
+//
+//	gmgo.RegisterFileStore("blobs", gmgo.LocalFileStore{Dir: "/var/lib/blobs"})
+//	gmgo.Setup(gmgo.DbConfig{HostURL: url, DBName: "users", Buckets: map[string]gmgo.BucketConfig{
+//		"rex_files": {Store: "blobs"},
+//	}})
+func RegisterFileStore(name string, store FileStore) {
+	fileStores[name] = store
+}
+
+// fileStore returns the file store registered with the given name
+func fileStore(name string) (FileStore, error) {
+	store, ok := fileStores[name]
+	if !ok {
+		return nil, fmt.Errorf("file store %s not registered", name)
+	}
+	return store, nil
+}
+
+// storeKey returns the key of the file content in a file store
+func storeKey(prefix, id string) string {
+	return prefix + "/" + id
+}
+
+// storeUpload streams the content written to a GridFileWriter to a file store
+type storeUpload struct {
+	store FileStore
+	key   string
+	doc   gridFSFile
+	pw    *io.PipeWriter
+	done  chan error
+	size  int64
+}
+
+func newStoreUpload(store FileStore, key string, doc gridFSFile) *storeUpload {
+	pr, pw := io.Pipe()
+	u := &storeUpload{store: store, key: key, doc: doc, pw: pw, done: make(chan error, 1)}
+	go func() {
+		err := store.Put(key, pr)
+		// unblocks the writer if the store stopped reading
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+	return u
+}
+
+func (u *storeUpload) Write(p []byte) (int, error) {
+	n, err := u.pw.Write(p)
+	u.size += int64(n)
+	return n, err
+}
+
+// abort fails the upload, so the store discards the content
+func (u *storeUpload) abort() {
+	u.pw.CloseWithError(errWriteAborted)
+}
+
+// close waits for the store to save the content and returns its size
+func (u *storeUpload) close() (int64, error) {
+	u.pw.Close()
+	return u.size, <-u.done
+}
+
+// GridFSStore is a FileStore keeping the content as files of a GridFS bucket named by the key, e.g. to keep large
+// files in a bucket of another database. The bucket itself must not have a Store.
+type GridFSStore struct {
+	Session *DbSession
+	Prefix  string
+}
+
+// NewGridFSStore creates the file store using the GridFS bucket with the given prefix
+func NewGridFSStore(session *DbSession, prefix string) *GridFSStore {
+	return &GridFSStore{Session: session, Prefix: prefix}
+}
+
+// Put stores the content as a new GridFS file
+func (gs *GridFSStore) Put(key string, r io.Reader) error {
+	_, _, err := gs.Session.SaveFromReader(key, "", gs.Prefix, r)
+	return err
+}
+
+// Open opens the latest GridFS file with the key as name
+func (gs *GridFSStore) Open(key string) (io.ReadSeekCloser, error) {
+	f, err := gs.Session.OpenFileByName(key, gs.Prefix)
+	if err == mgo.ErrNotFound {
+		return nil, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes all the GridFS files with the key as name
+func (gs *GridFSStore) Delete(key string) error {
+	files, err := gs.Session.ListRevisions(key, gs.Prefix)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if err := gs.Session.DeleteFile(f.ID, gs.Prefix); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// LocalFileStore is a FileStore keeping the content as files of a local directory, the key being the relative path
+type LocalFileStore struct {
+	Dir string
+}
+
+// path returns the path of the content, rejecting keys escaping the directory
+func (ls LocalFileStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if key == "" || clean != "/"+key {
+		return "", fmt.Errorf("invalid file store key %q", key)
+	}
+	return filepath.Join(ls.Dir, filepath.FromSlash(strings.TrimPrefix(clean, "/"))), nil
+}
+
+// Put writes the content to a temporary file renamed to the key path once complete
+func (ls LocalFileStore) Put(key string, r io.Reader) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), ".upload-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// Open opens the file at the key path
+func (ls LocalFileStore) Open(key string) (io.ReadSeekCloser, error) {
+	p, err := ls.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrContentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Delete removes the file at the key path
+func (ls LocalFileStore) Delete(key string) error {
+	p, err := ls.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// MigrateConfig configures MigrateFiles
+type MigrateConfig struct {
+	// Query selects the files to migrate, e.g. gmgo.Q{"length": bson.M{"$gt": 1 << 20}}. Nil migrates all the files
+	Query Q
+	// Progress is called after each file is migrated
+	Progress func(stats MigrateStats)
+}
+
+// MigrateStats is the progress of MigrateFiles
+type MigrateStats struct {
+	Files int
+	Bytes int64
+	// Skipped is the number of files deleted or migrated by someone else during the migration
+	Skipped int
+}
+
+// MigrateFiles moves the content of the files of the bucket to the file store with the given name, or to GridFS
+// chunks if the name is empty. For each file, the content is copied to the target, the files document is updated to
+// point to it, and the content is removed from its previous location. The files remain readable during the
+// migration. If the migration is interrupted, the files are either in their previous or new location, with at most
+// the content of one file left behind in one of the stores.
+//
+// For example:
+//
+//	gmgo.RegisterFileStore("s3", &gmgo.S3FileStore{Endpoint: "https://s3.us-east-1.amazonaws.com", Bucket: "blobs", ...})
+//	stats, err := session.MigrateFiles("rex_files", "s3", gmgo.MigrateConfig{})
+func (s *DbSession) MigrateFiles(prefix, target string, cfg MigrateConfig) (MigrateStats, error) {
+	stats := MigrateStats{}
+	if target != "" {
+		if _, err := fileStore(target); err != nil {
+			return stats, err
+		}
+	}
+
+	filter := bson.M{"metadata." + fileStoreKey: bson.M{"$ne": target}}
+	if target == "" {
+		filter = bson.M{"metadata." + fileStoreKey: bson.M{"$exists": true, "$ne": ""}}
+	}
+	query := Q(filter)
+	if cfg.Query != nil {
+		query = Q{"$and": []interface{}{cfg.Query, filter}}
+	}
+
+	var files []gridFSFile
+	err := s.retry(false, func() error {
+		return s.gridFS(prefix).Files.Find(query).Sort("_id").All(&files)
+	})
+	if err != nil {
+		return stats, err
+	}
+
+	for _, file := range files {
+		migrated, err := s.migrateFile(prefix, target, file)
+		if err != nil {
+			return stats, fmt.Errorf("migrating file %s: %s", fileID(file.ID), err)
+		}
+		if migrated {
+			stats.Files++
+			stats.Bytes += file.Length
+		} else {
+			stats.Skipped++
+		}
+		if cfg.Progress != nil {
+			cfg.Progress(stats)
+		}
+	}
+	return stats, nil
+}
+
+// migrateFile copies the content of the file to the target and switches the files document to it. It returns false
+// if the file was deleted or migrated meanwhile
+func (s *DbSession) migrateFile(prefix, target string, file gridFSFile) (bool, error) {
+	gfs := s.gridFS(prefix)
+	key := storeKey(prefix, fileID(file.ID))
+
+	var src io.ReadCloser
+	if file.store() == "" {
+		f, err := gfs.OpenId(file.ID)
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		src = f
+	} else {
+		store, err := fileStore(file.store())
+		if err != nil {
+			return false, err
+		}
+		if src, err = store.Open(key); err != nil {
+			return false, err
+		}
+	}
+	defer src.Close()
+
+	var update bson.M
+	var rollback func() error
+	if target == "" {
+		sum, err := s.writeChunks(prefix, file.ID, src)
+		if err != nil {
+			return false, err
+		}
+		update = bson.M{
+			"$set":   bson.M{"chunkSize": defaultChunkSize, "md5": sum},
+			"$unset": bson.M{"metadata." + fileStoreKey: ""},
+		}
+		rollback = func() error { return s.removeContent(prefix, gridFSFile{ID: file.ID}) }
+	} else {
+		store, _ := fileStore(target)
+		if err := store.Put(key, src); err != nil {
+			return false, err
+		}
+		update = bson.M{"$set": bson.M{"metadata." + fileStoreKey: target}}
+		rollback = func() error { return store.Delete(key) }
+	}
+
+	// the document is only switched if it still points to the source
+	selector := bson.M{"_id": file.ID, "metadata." + fileStoreKey: file.store()}
+	if file.store() == "" {
+		selector["metadata."+fileStoreKey] = bson.M{"$exists": false}
+	}
+	err := s.retry(true, func() error { return gfs.Files.Update(selector, update) })
+	if err != nil {
+		if rbErr := rollback(); rbErr != nil {
+			return false, fmt.Errorf("%s, and removing the copied content failed: %s", err, rbErr)
+		}
+		if err == mgo.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, s.retry(true, func() error { return s.removeContent(prefix, file) })
+}
+
+// writeChunks writes the content as GridFS chunks of the file and returns its MD5
+func (s *DbSession) writeChunks(prefix string, id interface{}, r io.Reader) (string, error) {
+	chunks := s.gridFS(prefix).Chunks
+	hash := md5.New()
+	buf := make([]byte, defaultChunkSize)
+	for n := 0; ; n++ {
+		size, err := io.ReadFull(r, buf)
+		if size > 0 {
+			hash.Write(buf[:size])
+			chunk := bson.M{"_id": bson.NewObjectId(), "files_id": id, "n": n, "data": buf[:size]}
+			if insErr := s.retry(true, func() error { return chunks.Insert(chunk) }); insErr != nil {
+				chunks.RemoveAll(bson.M{"files_id": id})
+				return "", insErr
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return hex.EncodeToString(hash.Sum(nil)), nil
+		}
+		if err != nil {
+			chunks.RemoveAll(bson.M{"files_id": id})
+			return "", err
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package gmgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// emptySHA256 is the SHA-256 of an empty payload
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3FileStore is a FileStore keeping the content as objects of an S3 compatible bucket, e.g. AWS S3 or MinIO. The
+// objects are addressed path style as <Endpoint>/<Bucket>/<key> and the requests are signed with AWS Signature V4.
+// As S3 needs the length of the content up front, Put spools the content to a temporary file.
+type S3FileStore struct {
+	// Endpoint is the URL of the service, e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Endpoint string
+	Bucket   string
+	// Region defaults to us-east-1
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Client defaults to http.DefaultClient
+	Client *http.Client
+	// TempDir is the directory of the temporary files, defaults to os.TempDir
+	TempDir string
+}
+
+// Put uploads the content as the object with the key
+func (ss *S3FileStore) Put(key string, r io.Reader) error {
+	tmp, err := ioutil.TempFile(ss.TempDir, "gmgo-s3-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPut, ss.objectURL(key), tmp)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		// an empty body that isn't http.NoBody is sent chunked, which S3 rejects
+		req.Body = http.NoBody
+	}
+	resp, err := ss.do(req, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open returns the reader of the object with the key. The object is read with range requests, so the reader can seek
+func (ss *S3FileStore) Open(key string) (io.ReadSeekCloser, error) {
+	req, err := http.NewRequest(http.MethodHead, ss.objectURL(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := ss.do(req, emptySHA256)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return &s3Reader{store: ss, key: key, size: resp.ContentLength}, nil
+}
+
+// Delete removes the object with the key
+func (ss *S3FileStore) Delete(key string) error {
+	req, err := http.NewRequest(http.MethodDelete, ss.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := ss.do(req, emptySHA256)
+	if err == ErrContentNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (ss *S3FileStore) objectURL(key string) string {
+	segments := strings.Split(ss.Bucket+"/"+key, "/")
+	for i, seg := range segments {
+		segments[i] = uriEncode(seg)
+	}
+	return strings.TrimSuffix(ss.Endpoint, "/") + "/" + strings.Join(segments, "/")
+}
+
+// do signs and sends the request. It returns ErrContentNotFound for 404 responses and an error for other non 2xx
+func (ss *S3FileStore) do(req *http.Request, payloadHash string) (*http.Response, error) {
+	region := ss.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	signS3Request(req, payloadHash, ss.AccessKey, ss.SecretKey, region, time.Now())
+
+	client := ss.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, ErrContentNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s: %s %s", req.Method, req.URL.Path, resp.Status, body)
+	}
+	return resp, nil
+}
+
+// s3Reader reads an object with a GET request from the current offset, issued on the first Read after a Seek
+type s3Reader struct {
+	store  *S3FileStore
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		req, err := http.NewRequest(http.MethodGet, r.store.objectURL(r.key), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+		resp, err := r.store.do(req, emptySHA256)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return r.offset, errors.New("seek to negative offset")
+	}
+	if offset != r.offset {
+		r.Close()
+		r.offset = offset
+	}
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// signS3Request adds the AWS Signature V4 headers to the request, signing the host, range and x-amz-* headers
+func signS3Request(req *http.Request, payloadHash, accessKey, secretKey, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "range" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		accessKey, scope, signedHeaders, signature))
+}
+
+func canonicalQuery(values url.Values) string {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, k := range keys {
+		vs := values[k]
+		sort.Strings(vs)
+		for _, v := range vs {
+			pairs = append(pairs, uriEncode(k)+"="+uriEncode(v))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// uriEncode encodes all the characters except the unreserved ones, as required by Signature V4
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,99 @@
+package gmgo
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// errTailableFindCommand is returned by tailable iterators using options that require the find command
+var errTailableFindCommand = errors.New("tailable iterators don't support HintIndex, NoCursorTimeout or AllowPartialResults")
+
+// findCommandResult is the response of the find command
+type findCommandResult struct {
+	Cursor struct {
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		ID         int64      `bson:"id"`
+	} `bson:"cursor"`
+}
+
+// requiresFindCommand returns true if the config uses options that mgo.Query doesn't support,
+// in which case the iterator is opened by running the find command directly
+func (cfg IteratorConfig) requiresFindCommand() bool {
+	return cfg.HintIndex != "" || cfg.NoCursorTimeout || cfg.AllowPartialResults
+}
+
+// findCommand builds the find command for the iterator config
+func (cfg IteratorConfig) findCommand(collection string, filter Q) bson.D {
+	if filter == nil {
+		filter = Q{}
+	}
+	cmd := bson.D{{Name: "find", Value: collection}, {Name: "filter", Value: filter}}
+	add := func(name string, value interface{}) {
+		cmd = append(cmd, bson.DocElem{Name: name, Value: value})
+	}
+
+	if len(cfg.SortBy) > 0 {
+		add("sort", sortDocument(cfg.SortBy))
+	}
+	if len(cfg.Projection) > 0 {
+		add("projection", sel(cfg.Projection...))
+	}
+	if cfg.HintIndex != "" {
+		add("hint", cfg.HintIndex)
+	} else if len(cfg.Hint) > 0 {
+		add("hint", sortDocument(cfg.Hint))
+	}
+	if cfg.Skip > 0 {
+		add("skip", cfg.Skip)
+	}
+	if cfg.Limit > 0 {
+		add("limit", cfg.Limit)
+	}
+	if cfg.PageSize > 0 {
+		add("batchSize", cfg.PageSize)
+	}
+	if cfg.MaxTime > 0 {
+		add("maxTimeMS", int64(cfg.MaxTime/time.Millisecond))
+	}
+	if cfg.Comment != "" {
+		add("comment", cfg.Comment)
+	}
+	if cfg.Collation != nil {
+		add("collation", cfg.Collation)
+	}
+	if cfg.NoCursorTimeout {
+		add("noCursorTimeout", true)
+	}
+	if cfg.AllowPartialResults {
+		add("allowPartialResults", true)
+	}
+	return cmd
+}
+
+// findCommand opens the iterator by running the find command
+func (pd *DocumentIterator) findCommand(cfg IteratorConfig) (*mgo.Iter, error) {
+	coll := pd.session.collection(pd.collection)
+
+	result := findCommandResult{}
+	if err := coll.Database.Run(cfg.findCommand(pd.collection, pd.filter), &result); err != nil {
+		return nil, err
+	}
+	return coll.NewIter(pd.session.Session, result.Cursor.FirstBatch, result.Cursor.ID, nil), nil
+}
+
+// sortDocument converts the field list, with - prefix for descending order, to the ordered key document
+func sortDocument(fields []string) bson.D {
+	doc := make(bson.D, 0, len(fields))
+	for _, f := range fields {
+		order := 1
+		if strings.HasPrefix(f, "-") {
+			order = -1
+		}
+		doc = append(doc, bson.DocElem{Name: strings.TrimLeft(f, "+-"), Value: order})
+	}
+	return doc
+}
@@ -0,0 +1,53 @@
+package gmgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestFindCommand(t *testing.T) {
+	cfg := IteratorConfig{
+		SortBy:              []string{"-createdDate", "fullName"},
+		Projection:          []string{"fullName"},
+		HintIndex:           "state_1",
+		Skip:                10,
+		Limit:               20,
+		MaxTime:             2 * time.Second,
+		Comment:             "report",
+		AllowPartialResults: true,
+	}
+	if !cfg.requiresFindCommand() {
+		t.Fatal("Expected find command for HintIndex and AllowPartialResults")
+	}
+
+	cmd := cfg.findCommand("user", Q{"state": "CA"})
+	expected := bson.D{
+		{Name: "find", Value: "user"},
+		{Name: "filter", Value: Q{"state": "CA"}},
+		{Name: "sort", Value: bson.D{{Name: "createdDate", Value: -1}, {Name: "fullName", Value: 1}}},
+		{Name: "projection", Value: bson.M{"fullName": 1}},
+		{Name: "hint", Value: "state_1"},
+		{Name: "skip", Value: 10},
+		{Name: "limit", Value: 20},
+		{Name: "maxTimeMS", Value: int64(2000)},
+		{Name: "comment", Value: "report"},
+		{Name: "allowPartialResults", Value: true},
+	}
+	if !reflect.DeepEqual(cmd, expected) {
+		t.Errorf("Unexpected find command\n%v\nexpected\n%v", cmd, expected)
+	}
+}
+
+func TestTailableFindCommand(t *testing.T) {
+	itr := new(DocumentIterator)
+	itr.Load(IteratorConfig{Tailable: true, AwaitTimeout: time.Second, HintIndex: "state_1"})
+	if itr.Error() != errTailableFindCommand {
+		t.Errorf("Expected tailable iterator to be rejected, got %v", itr.Error())
+	}
+	if itr.FetchNext(new(user)) {
+		t.Error("Expected no documents")
+	}
+}
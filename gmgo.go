@@ -2,7 +2,7 @@ package gmgo
 
 import (
 	"errors"
-	"strings"
+	"io"
 
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
@@ -26,11 +26,49 @@ type Db struct {
 	mainSession *mgo.Session
 }
 
+// Default connection tuning values applied by Setup when the corresponding DbConfig field is left zero.
+const (
+	// DefaultDialTimeout is the time allowed to establish the initial connection
+	DefaultDialTimeout = 10 * time.Second
+	// DefaultSocketTimeout is the time allowed for a single read or write on a socket
+	DefaultSocketTimeout = 1 * time.Minute
+	// DefaultSyncTimeout is the time allowed to wait for a suitable server (e.g. primary) to become available
+	DefaultSyncTimeout = 1 * time.Minute
+	// DefaultPoolLimit is the maximum number of sockets in use per server, same as mgo.DefaultConnectionPoolLimit
+	DefaultPoolLimit = mgo.DefaultConnectionPoolLimit
+)
+
 // DbConfig represents the configuration params needed for MongoDB connection
 type DbConfig struct {
 	HostURL, DBName, UserName, Password string
 	Hosts                               []string
 	Mode                                int
+
+	//DialTimeout is the time allowed to establish the connection. Defaults to DefaultDialTimeout
+	DialTimeout time.Duration
+	//SocketTimeout is the time allowed for a socket read or write. Defaults to DefaultSocketTimeout
+	SocketTimeout time.Duration
+	//SyncTimeout is the time allowed to find a suitable server. Defaults to DefaultSyncTimeout
+	SyncTimeout time.Duration
+	//PoolLimit is the maximum number of sockets per server. Defaults to the maxPoolSize of HostURL, or DefaultPoolLimit
+	PoolLimit int
+	//PoolTimeout is the time to wait for a free socket when PoolLimit is reached. Zero waits forever
+	PoolTimeout time.Duration
+	//MinPoolSize is the number of sockets kept open per server even when idle. Defaults to the minPoolSize of
+	//HostURL, or zero to keep none
+	MinPoolSize int
+	//MaxIdleTime is how long a socket may stay unused in the pool before it's closed. Defaults to the maxIdleTimeMS
+	//of HostURL, or zero to never close idle sockets
+	MaxIdleTime time.Duration
+
+	//Retry is the retry policy for transient failures like primary step down. Nil disables retries
+	Retry *RetryPolicy
+	//SlowQuery enables reporting of queries slower than a threshold. Nil disables the monitor
+	SlowQuery *SlowQueryConfig
+	//Buckets configures the GridFS buckets by prefix, see BucketConfig
+	Buckets map[string]BucketConfig
+	//KeyProvider provides the keys of the encrypted GridFS files, see FileEncoding
+	KeyProvider KeyProvider
 }
 
 // DbSession mgo session wrapper
@@ -63,11 +101,18 @@ type Document interface {
 //  	u := result.(*user)
 //	}
 type DocumentIterator struct {
-	iterator *mgo.Iter
-	query    *mgo.Query
-	pageSize int
-	loaded   bool
-	err      error
+	iterator   *mgo.Iter
+	query      *mgo.Query
+	pageSize   int
+	loaded     bool
+	err        error
+	session    *DbSession
+	collection string
+	filter     Q
+	checkpoint *checkpointState
+	config     IteratorConfig
+	elapsed    time.Duration
+	observed   bool
 }
 
 //IteratorConfig defines different iterator config to load the document interator
@@ -77,11 +122,39 @@ type IteratorConfig struct {
 	PageSize int
 	//Limit used limit the number of documents
 	Limit int
+	//Skip number of documents to skip. Prefer Paginate for paging over large collections
+	Skip int
 	//Snashopt ($snapshot) operator prevents the cursor from returning a document more than
 	//once because an intervening write operation results in a move of the document.
+	//DEPRECATED - removed in MongoDB 4.0, use Hint: []string{"_id"} instead
 	Snapshot bool
 	//SortBy list of field names to sort the result
 	SortBy []string
+	//Projection list of field names to return, same as FindAllWithFields
+	Projection []string
+	//Hint forces the index with the given keys, e.g. []string{"state", "-createdDate"}
+	Hint []string
+	//HintIndex forces the index with the given name
+	HintIndex string
+	//MaxTime is the time limit for processing the query on the server
+	MaxTime time.Duration
+	//Comment is attached to the query to identify it in the profiler and logs
+	Comment string
+	//Collation defines language specific rules for string comparison
+	Collation *mgo.Collation
+	//NoCursorTimeout prevents the server from closing the cursor after 10 minutes of inactivity.
+	//Close must be called on the iterator to release the cursor
+	NoCursorTimeout bool
+	//AllowPartialResults returns the results of the available shards instead of an error when some shards are down
+	AllowPartialResults bool
+	//Tailable opens a tailable, await data cursor on a capped collection. See mgo.Query.Tail().
+	//It can't be combined with HintIndex, NoCursorTimeout or AllowPartialResults
+	Tailable bool
+	//AwaitTimeout is how long FetchNext waits for new documents on a tailable cursor before it returns
+	//false with IsTimeout true. Negative waits forever
+	AwaitTimeout time.Duration
+	//Checkpoint makes the iterator resumable, see CheckpointConfig. Documents are sorted by _id and SortBy is ignored
+	Checkpoint *CheckpointConfig
 }
 
 // File file representation
@@ -91,6 +164,16 @@ type File struct {
 	ContentType string
 	ByteLength  int
 	Data        []byte
+	//Metadata is stored in the metadata field of the GridFS file document
+	Metadata   bson.M
+	UploadDate time.Time
+	MD5        string
+	//SHA256 is the hex SHA-256 of the content, computed by gmgo when the file is saved
+	SHA256 string
+	//ExpiresAt is the time after which the file is removed by the file reaper. Zero never expires
+	ExpiresAt time.Time
+	//Encoding compresses and encrypts the content. Zero uses the encoding of the bucket
+	Encoding FileEncoding
 }
 
 func (pd *DocumentIterator) loadInternal() {
@@ -111,28 +194,76 @@ func (pd *DocumentIterator) loadInternal() {
 // fetch with page size
 // 	pd.Load(IteratorConfig{PageSize: 200})
 func (pd *DocumentIterator) Load(cfg IteratorConfig) {
+	pd.loaded = true
+	started := time.Now()
+	defer pd.observeIterator(started, false)
+	if cfg.Checkpoint != nil {
+		if pd.err = pd.loadCheckpoint(*cfg.Checkpoint); pd.err != nil {
+			return
+		}
+		cfg.SortBy = []string{"_id"}
+	}
+
+	pd.config = cfg
+	pd.pageSize = cfg.PageSize
+	if cfg.requiresFindCommand() {
+		if cfg.Tailable {
+			// iterators of the find command can't wait for new documents with the await timeout
+			pd.err = errTailableFindCommand
+			return
+		}
+		pd.iterator, pd.err = pd.findCommand(cfg)
+		return
+	}
+
+	pd.query = cfg.apply(pd.query)
+	if cfg.Tailable {
+		pd.iterator = pd.query.Tail(cfg.AwaitTimeout)
+		return
+	}
+	pd.iterator = pd.query.Iter()
+}
+
+// apply sets the query options of the config to the mgo query
+func (cfg IteratorConfig) apply(q *mgo.Query) *mgo.Query {
 	if cfg.PageSize >= 100 {
-		pd.query = pd.query.Batch(cfg.PageSize)
+		q = q.Batch(cfg.PageSize)
+	}
+	if cfg.Skip > 0 {
+		q = q.Skip(cfg.Skip)
 	}
 	if cfg.Limit > 0 {
-		pd.query = pd.query.Limit(cfg.Limit)
+		q = q.Limit(cfg.Limit)
 	}
 	if cfg.Snapshot {
-		pd.query = pd.query.Snapshot()
+		q = q.Snapshot()
 	}
-	if cfg.SortBy != nil && len(cfg.SortBy) > 0 {
-		pd.query = pd.query.Sort(strings.Join(cfg.SortBy, ","))
+	if len(cfg.SortBy) > 0 {
+		q = q.Sort(cfg.SortBy...)
 	}
-
-	pd.iterator = pd.query.Iter()
-	pd.loaded = true
+	if len(cfg.Projection) > 0 {
+		q = q.Select(sel(cfg.Projection...))
+	}
+	if len(cfg.Hint) > 0 {
+		q = q.Hint(cfg.Hint...)
+	}
+	if cfg.MaxTime > 0 {
+		q = q.SetMaxTime(cfg.MaxTime)
+	}
+	if cfg.Comment != "" {
+		q = q.Comment(cfg.Comment)
+	}
+	if cfg.Collation != nil {
+		q = q.Collation(cfg.Collation)
+	}
+	return q
 }
 
 //HasMore returns true if paged document has still more documents to fetch.
 //DEPRECATED Use FetchNext in favor of this
 func (pd *DocumentIterator) HasMore() bool {
 	pd.loadInternal()
-	return !pd.iterator.Done()
+	return pd.iterator != nil && !pd.iterator.Done()
 }
 
 //Next returns the next result object in the paged document. If there's no element it will check for error
@@ -140,8 +271,13 @@ func (pd *DocumentIterator) HasMore() bool {
 //DEPRECATED - Use FetchNext in favor of this
 func (pd *DocumentIterator) Next(d Document) error {
 	pd.loadInternal()
+	if pd.iterator == nil {
+		return pd.err
+	}
 
+	started := time.Now()
 	hasNext := pd.iterator.Next(d)
+	pd.observeIterator(started, !hasNext)
 	if hasNext {
 		return nil
 	}
@@ -149,7 +285,8 @@ func (pd *DocumentIterator) Next(d Document) error {
 }
 
 //FetchNext retrieves the next document from the result set. For more details see mgo.Iter.Next()
-//return false if there are no more records to fetch
+//return false if there are no more records to fetch. See Documents and Stream for range and channel
+//based alternatives.
 //
 //Usage:
 //	aitr := session.DocumentIterator(gmgo.Q{}, data.User{}.CollectionName())
@@ -171,19 +308,30 @@ func (pd *DocumentIterator) Next(d Document) error {
 //  }
 func (pd *DocumentIterator) FetchNext(d interface{}) bool {
 	pd.loadInternal()
+	if pd.iterator == nil {
+		return false
+	}
 
-	hasNext := pd.iterator.Next(d)
+	started := time.Now()
+	var hasNext bool
+	if pd.checkpoint != nil {
+		hasNext = pd.fetchNextWithCheckpoint(d)
+	} else {
+		hasNext = pd.iterator.Next(d)
+	}
+	pd.observeIterator(started, !hasNext)
 	if hasNext {
 		return true
 	}
-
-	pd.err = pd.iterator.Err()
+	if pd.checkpoint == nil {
+		pd.err = pd.iterator.Err()
+	}
 	return false
 }
 
 //Error returns iteration error
 func (pd *DocumentIterator) Error() error {
-	if pd.err != nil {
+	if pd.err != nil || pd.iterator == nil {
 		return pd.err
 	}
 	return pd.iterator.Err()
@@ -191,21 +339,30 @@ func (pd *DocumentIterator) Error() error {
 
 //IsTimeout returns true if the iterator timed out
 func (pd *DocumentIterator) IsTimeout() bool {
-	return pd.iterator.Timeout()
+	return pd.iterator != nil && pd.iterator.Timeout()
 }
 
 //Close closes the document iterator
 func (pd *DocumentIterator) Close() error {
 	pd.loadInternal()
+	if pd.iterator == nil {
+		return pd.err
+	}
+	pd.observeIterator(time.Now(), true)
 	return pd.iterator.Close()
 }
 
 //All returns all the documents in the iterator.
 func (pd *DocumentIterator) All(document Document) (interface{}, error) {
 	pd.loadInternal()
+	if pd.iterator == nil {
+		return nil, pd.err
+	}
 
 	documents := slice(document)
+	started := time.Now()
 	err := pd.iterator.All(documents)
+	pd.observeIterator(started, true)
 	if err != nil {
 		return nil, err
 	}
@@ -224,6 +381,12 @@ func (s *DbSession) Clone() *DbSession {
 	return &DbSession{db: s.db, Session: s.Session.Clone()}
 }
 
+// Copy returns the copy of current DB session. Unlike Clone, the copied
+// session gets its own socket connection
+func (s *DbSession) Copy() *DbSession {
+	return &DbSession{db: s.db, Session: s.Session.Copy()}
+}
+
 // Close closes the underlying mgo session
 func (s *DbSession) Close() {
 	s.Session.Close()
@@ -256,7 +419,10 @@ func (s *DbSession) executeFindAll(query Q, document Document, qf queryFunc) (in
 	documents := slice(document)
 	q := s.findQuery(document, query)
 
-	if err := qf(q, documents); err != nil {
+	started := time.Now()
+	err := s.retry(false, func() error { return qf(q, documents) })
+	s.observeQuery("FindAll", document.CollectionName(), query, &IteratorConfig{}, time.Since(started))
+	if err != nil {
 		if err.Error() != mgo.ErrNotFound.Error() {
 			log.Printf("Error fetching %s list. Error: %s\n", document.CollectionName(), err)
 		}
@@ -273,7 +439,7 @@ func (s *DbSession) Collection(d Document) *mgo.Collection {
 // Save inserts the given document that represents the collection to the database.
 func (s *DbSession) Save(document Document) error {
 	coll := s.collection(document.CollectionName())
-	err := coll.Insert(document)
+	err := s.retry(true, func() error { return coll.Insert(document) })
 	if err != nil {
 		return err
 	}
@@ -283,12 +449,14 @@ func (s *DbSession) Save(document Document) error {
 // Update updates the given document based on given selector
 func (s *DbSession) Update(selector Q, document Document) error {
 	coll := s.collection(document.CollectionName())
-	return coll.Update(selector, document)
+	return s.retry(true, func() error { return coll.Update(selector, document) })
 }
 
 //UpdateFieldValue updates the single field with a given value for a collection name based query
 func (s *DbSession) UpdateFieldValue(query Q, collectionName, field string, value interface{}) error {
-	return s.collection(collectionName).Update(query, bson.M{"$set": bson.M{field: value}})
+	return s.retry(true, func() error {
+		return s.collection(collectionName).Update(query, bson.M{"$set": bson.M{field: value}})
+	})
 }
 
 // FindByID find the object by id. Returns error if it's not able to find the document. If document is found
@@ -298,7 +466,10 @@ func (s *DbSession) FindByID(id string, result Document) error {
 		return errors.New("invalid id")
 	}
 	coll := s.collection(result.CollectionName())
-	if err := coll.FindId(bson.ObjectIdHex(id)).One(result); err != nil {
+	started := time.Now()
+	err := s.retry(false, func() error { return coll.FindId(bson.ObjectIdHex(id)).One(result) })
+	s.observeQuery("FindByID", result.CollectionName(), Q{"_id": bson.ObjectIdHex(id)}, &IteratorConfig{Limit: 1}, time.Since(started))
+	if err != nil {
 		if err.Error() != mgo.ErrNotFound.Error() {
 			log.Printf("Error fetching %s with id %s. Error: %s\n", result.CollectionName(), id, err)
 		}
@@ -310,7 +481,10 @@ func (s *DbSession) FindByID(id string, result Document) error {
 // Find the data based on given query
 func (s *DbSession) Find(query Q, document Document) error {
 	q := s.findQuery(document, query)
-	if err := q.One(document); err != nil {
+	started := time.Now()
+	err := s.retry(false, func() error { return q.One(document) })
+	s.observeQuery("Find", document.CollectionName(), query, &IteratorConfig{Limit: 1}, time.Since(started))
+	if err != nil {
 		if err.Error() != mgo.ErrNotFound.Error() {
 			log.Printf("Error fetching %s with query %s. Error: %s\n", document.CollectionName(), query, err)
 		}
@@ -322,7 +496,7 @@ func (s *DbSession) Find(query Q, document Document) error {
 // FindByRef finds the document based on given db reference.
 func (s *DbSession) FindByRef(ref *mgo.DBRef, document Document) error {
 	q := s.Session.DB(s.db.Config.DBName).FindRef(ref)
-	if err := q.One(document); err != nil {
+	if err := s.retry(false, func() error { return q.One(document) }); err != nil {
 		if err.Error() != mgo.ErrNotFound.Error() {
 			log.Printf("Error fetching %s. Error: %s\n", document.CollectionName(), err)
 		}
@@ -356,12 +530,48 @@ func (s *DbSession) FindWithLimit(limit int, query Q, document Document) (interf
 	return s.executeFindAll(query, document, fn)
 }
 
+// FindAllWithConfig returns all the documents based on given query, using the sort, projection, hint and
+// other query options of the IteratorConfig
+func (s *DbSession) FindAllWithConfig(query Q, cfg IteratorConfig, document Document) (interface{}, error) {
+	itr := s.DocumentIterator(query, document.CollectionName())
+	itr.Load(cfg)
+	defer itr.Close()
+
+	results, err := itr.All(document)
+	if err != nil {
+		log.Printf("Error fetching %s list. Error: %s\n", document.CollectionName(), err)
+		return nil, err
+	}
+	return results, nil
+}
+
+// FindWithConfig finds the first document based on given query using the query options of the IteratorConfig.
+// It returns mgo.ErrNotFound if there's no matching document
+func (s *DbSession) FindWithConfig(query Q, cfg IteratorConfig, document Document) error {
+	cfg.Limit = 1
+	itr := s.DocumentIterator(query, document.CollectionName())
+	itr.Load(cfg)
+	defer itr.Close()
+
+	if itr.FetchNext(document) {
+		return nil
+	}
+	if err := itr.Error(); err != nil {
+		log.Printf("Error fetching %s with query %s. Error: %s\n", document.CollectionName(), query, err)
+		return err
+	}
+	return mgo.ErrNotFound
+}
+
 //DocumentIterator returns the document iterator which could be used to fetch documents
 //as batch with batch size and other config params
 func (s *DbSession) DocumentIterator(query Q, collection string) *DocumentIterator {
 	q := s.findQueryByCollectionName(collection, query)
 	iter := new(DocumentIterator)
 	iter.query = q
+	iter.session = s
+	iter.collection = collection
+	iter.filter = query
 
 	return iter
 }
@@ -369,7 +579,10 @@ func (s *DbSession) DocumentIterator(query Q, collection string) *DocumentIterat
 // Exists check if the document exists for given query
 func (s *DbSession) Exists(query Q, document Document) (bool, error) {
 	q := s.findQuery(document, query)
-	if err := q.Select(bson.M{"_id": 1}).Limit(1).One(document); err != nil {
+	started := time.Now()
+	err := s.retry(false, func() error { return q.Select(bson.M{"_id": 1}).Limit(1).One(document) })
+	s.observeQuery("Exists", document.CollectionName(), query, &IteratorConfig{Limit: 1, Projection: []string{"_id"}}, time.Since(started))
+	if err != nil {
 		if err.Error() == mgo.ErrNotFound.Error() {
 			return false, nil
 		}
@@ -380,61 +593,93 @@ func (s *DbSession) Exists(query Q, document Document) (bool, error) {
 
 //Remove removes the given document type based on the query
 func (s *DbSession) Remove(query Q, document Document) error {
-	return s.collection(document.CollectionName()).Remove(query)
+	return s.retry(true, func() error {
+		return s.collection(document.CollectionName()).Remove(query)
+	})
 }
 
 //RemoveAll removes all the document matching given selector query
 func (s *DbSession) RemoveAll(query Q, document Document) error {
-	_, err := s.collection(document.CollectionName()).RemoveAll(query)
-	return err
+	return s.retry(true, func() error {
+		_, err := s.collection(document.CollectionName()).RemoveAll(query)
+		return err
+	})
 }
 
-// Pipe returns the pipe for a given query and document
+// Pipe returns the pipe for a given query and document. The pipe runs when it's iterated, so it's neither
+// retried nor reported by the slow query monitor; use PipeAll for that
 func (s *DbSession) Pipe(pipeline interface{}, document Document) *mgo.Pipe {
 	return s.collection(document.CollectionName()).Pipe(pipeline)
 }
 
-//SaveFile saves the given file in a gridfs
-func (s *DbSession) SaveFile(file File, prefix string) (string, error) {
-	f, err := s.gridFS(prefix).Create(file.Name)
+// PipeAll runs the aggregation pipeline and returns all the resulting documents decoded to the document type.
+// Unlike Pipe, it's retried on transient errors and reported by the slow query monitor
+func (s *DbSession) PipeAll(pipeline interface{}, document Document) (interface{}, error) {
+	documents := slice(document)
+	started := time.Now()
+	err := s.retry(false, func() error { return s.Pipe(pipeline, document).All(documents) })
+	s.observeQuery("PipeAll", document.CollectionName(), pipeline, nil, time.Since(started))
 	if err != nil {
-		return "", err
+		log.Printf("Error aggregating %s. Error: %s\n", document.CollectionName(), err)
+		return nil, err
 	}
+	return results(documents)
+}
 
-	f.SetContentType(file.ContentType)
-	_, err = f.Write(file.Data)
+//SaveFile saves the given file in a gridfs. The whole content must be in File.Data, use CreateFile or
+//SaveFromReader to stream large files
+func (s *DbSession) SaveFile(file File, prefix string) (string, error) {
+	w, err := s.CreateFile(file.Name, file.ContentType, prefix)
 	if err != nil {
 		return "", err
 	}
-	defer f.Close()
+	w.SetMetadata(file.Metadata)
+	w.SetExpiresAt(file.ExpiresAt)
+	if file.Encoding != (FileEncoding{}) {
+		w.SetEncoding(file.Encoding)
+	}
 
-	fileID := f.Id().(bson.ObjectId)
+	if _, err = w.Write(file.Data); err != nil {
+		w.Abort()
+		w.Close()
+		return "", err
+	}
+	if err = w.Close(); err != nil {
+		return "", err
+	}
 
-	return fileID.Hex(), nil
+	return w.ID(), nil
 }
 
-//ReadFile read file based on given id
+//ReadFile read file based on given id. The whole content is loaded in File.Data, use OpenFile or CopyTo
+//to stream large files
 func (s *DbSession) ReadFile(id, prefix string, file *File) error {
-	f, err := s.gridFS(prefix).OpenId(bson.ObjectIdHex(id))
+	r, err := s.OpenFile(id, prefix)
 	if err != nil {
 		return err
 	}
-	n := f.Size()
-	if n == 0 {
-		n = 8192
-	}
-	b := make([]byte, n)
-	_, err = f.Read(b)
+	defer r.Close()
 
-	err = f.Close()
-	if err != nil {
+	info := r.Info()
+	b := make([]byte, info.Size)
+	if _, err = io.ReadFull(r, b); err != nil {
+		return err
+	}
+	if err = r.Close(); err != nil {
 		return err
 	}
 
 	file.ID = id
 	file.Data = b
-	file.Name = f.Name()
-	file.ContentType = f.ContentType()
+	file.Name = info.Name
+	file.ContentType = info.ContentType
+	file.ByteLength = len(b)
+	file.Metadata = info.Metadata
+	file.UploadDate = info.UploadDate
+	file.MD5 = info.MD5
+	file.SHA256 = info.SHA256
+	file.ExpiresAt = info.ExpiresAt
+	file.Encoding = info.Encoding
 
 	return nil
 }
@@ -455,21 +700,27 @@ func Setup(dbConfig DbConfig) error {
 		return errors.New("Invalid connection info. Missing host and db info")
 	}
 
-	var session *mgo.Session
-	var err error
+	dbConfig = dbConfig.withDefaults()
+
+	var mongoDBDialInfo *mgo.DialInfo
 	if dbConfig.Hosts != nil && dbConfig.DBName != "" {
-		mongoDBDialInfo := &mgo.DialInfo{
+		mongoDBDialInfo = &mgo.DialInfo{
 			Addrs:    dbConfig.Hosts,
-			Timeout:  10 * time.Second,
 			Database: dbConfig.DBName,
 			Username: dbConfig.UserName,
 			Password: dbConfig.Password,
 		}
-		session, err = mgo.DialWithInfo(mongoDBDialInfo)
 	} else {
-		session, err = mgo.DialWithTimeout(dbConfig.HostURL, 10*time.Second)
+		info, err := mgo.ParseURL(dbConfig.HostURL)
+		if err != nil {
+			log.Printf("MongoDB connection failed : %s. Exiting the program.\n", err)
+			return err
+		}
+		mongoDBDialInfo = info
 	}
+	dbConfig.applyDialInfo(mongoDBDialInfo)
 
+	session, err := mgo.DialWithInfo(mongoDBDialInfo)
 	if err != nil {
 		log.Printf("MongoDB connection failed : %s. Exiting the program.\n", err)
 		return err
@@ -477,6 +728,9 @@ func Setup(dbConfig DbConfig) error {
 
 	//starting with primary preferred, but individual query can change mode per copied session
 	session.SetMode(mgo.Strong, true)
+	//timeouts set on the main session are inherited by every DbSession copied from it
+	session.SetSocketTimeout(dbConfig.SocketTimeout)
+	session.SetSyncTimeout(dbConfig.SyncTimeout)
 	log.Println("Connected to MongoDB successfully")
 
 	/* Initialized database object with global session*/
@@ -485,6 +739,42 @@ func Setup(dbConfig DbConfig) error {
 	return nil
 }
 
+// withDefaults returns a copy of the config with zero valued tuning fields set to their defaults
+func (c DbConfig) withDefaults() DbConfig {
+	if c.DialTimeout <= 0 {
+		c.DialTimeout = DefaultDialTimeout
+	}
+	if c.SocketTimeout <= 0 {
+		c.SocketTimeout = DefaultSocketTimeout
+	}
+	if c.SyncTimeout <= 0 {
+		c.SyncTimeout = DefaultSyncTimeout
+	}
+	return c
+}
+
+// applyDialInfo copies the connection pool and timeout settings to the dial info. The pool settings are only
+// applied when set, so the ones parsed from the connection string are kept
+func (c DbConfig) applyDialInfo(info *mgo.DialInfo) {
+	info.Timeout = c.DialTimeout
+	info.ReadTimeout = c.SocketTimeout
+	info.WriteTimeout = c.SocketTimeout
+	if c.PoolLimit > 0 {
+		info.PoolLimit = c.PoolLimit
+	} else if info.PoolLimit <= 0 {
+		info.PoolLimit = DefaultPoolLimit
+	}
+	if c.PoolTimeout > 0 {
+		info.PoolTimeout = c.PoolTimeout
+	}
+	if c.MinPoolSize > 0 {
+		info.MinPoolSize = c.MinPoolSize
+	}
+	if c.MaxIdleTime > 0 {
+		info.MaxIdleTimeMS = int(c.MaxIdleTime / time.Millisecond)
+	}
+}
+
 func sel(q ...string) (r bson.M) {
 	r = make(bson.M, len(q))
 	for _, s := range q {
@@ -5,6 +5,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
 )
 
@@ -120,3 +121,36 @@ func xxTestReadGridFSFile(t *testing.T) {
 	}
 	fmt.Printf("File name:%s, Content Type: %s\n", file.Name, file.ContentType)
 }
+
+func TestDbConfigDefaults(t *testing.T) {
+	cfg := DbConfig{PoolLimit: 50, MaxIdleTime: 2 * time.Minute}.withDefaults()
+	if cfg.DialTimeout != DefaultDialTimeout || cfg.SocketTimeout != DefaultSocketTimeout || cfg.SyncTimeout != DefaultSyncTimeout {
+		t.Errorf("Expected default timeouts, got %+v", cfg)
+	}
+	if cfg.PoolLimit != 50 {
+		t.Errorf("Expected pool limit 50, got %d", cfg.PoolLimit)
+	}
+
+	info := new(mgo.DialInfo)
+	cfg.applyDialInfo(info)
+	if info.Timeout != DefaultDialTimeout || info.PoolLimit != 50 || info.MaxIdleTimeMS != 120000 {
+		t.Errorf("Dial info not applied %+v", info)
+	}
+}
+
+func TestDialInfoKeepsURLPoolOptions(t *testing.T) {
+	info, err := mgo.ParseURL("mongodb://localhost:27017/users?maxPoolSize=10&minPoolSize=2&maxIdleTimeMS=30000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	DbConfig{HostURL: "mongodb://localhost:27017/users"}.withDefaults().applyDialInfo(info)
+	if info.PoolLimit != 10 || info.MinPoolSize != 2 || info.MaxIdleTimeMS != 30000 {
+		t.Errorf("Pool options of the URL overwritten %+v", info)
+	}
+
+	info = new(mgo.DialInfo)
+	DbConfig{}.withDefaults().applyDialInfo(info)
+	if info.PoolLimit != DefaultPoolLimit {
+		t.Errorf("Expected default pool limit, got %d", info.PoolLimit)
+	}
+}
@@ -0,0 +1,791 @@
+package gmgo
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	// fileSHA256Key is the metadata field holding the SHA-256 of the file content
+	fileSHA256Key = "sha256"
+	// fileRefsKey is the metadata field holding the reference count of deduplicated files
+	fileRefsKey = "refs"
+	// fileExpiresAtKey is the metadata field holding the expiry time of the file
+	fileExpiresAtKey = "expiresAt"
+	// fileStoreKey is the metadata field holding the name of the FileStore of files not stored in GridFS chunks
+	fileStoreKey = "store"
+)
+
+// BucketConfig configures a GridFS bucket, set in DbConfig.Buckets by the bucket prefix
+type BucketConfig struct {
+	// Dedup stores identical content once. A new file with the same SHA-256 and length as a file already
+	// stored is discarded when the writer is closed, and the id of the existing file is returned instead with
+	// its reference count incremented. DeleteFile removes the content when the last reference is deleted.
+	// Deduplicated files share their name and metadata, which are those of the first upload, and expire when
+	// all their references have expired.
+	Dedup bool
+	// Revisions is the number of revisions kept per file name. Older revisions are deleted when a new
+	// revision is saved. Zero keeps all the revisions
+	Revisions int
+	// Store is the name of the FileStore, registered with RegisterFileStore, holding the content of new files.
+	// The files document is kept in the bucket as a pointer to the content. Empty stores the content in GridFS
+	// chunks. Dedup only applies to content stored in GridFS
+	Store string
+	// Encoding compresses and encrypts the content of new files, unless the file sets its own encoding. Files are
+	// only deduplicated with files of the same encoding
+	Encoding FileEncoding
+}
+
+// bucketConfig returns the configuration of the bucket with the given prefix
+func (s *DbSession) bucketConfig(prefix string) BucketConfig {
+	return s.db.Config.Buckets[prefix]
+}
+
+// FileInfo describes a file stored in GridFS
+type FileInfo struct {
+	ID          string
+	Name        string
+	ContentType string
+	Size        int64
+	MD5         string
+	// SHA256 is the hex SHA-256 of the content, empty for files not saved by gmgo. For encrypted files it's an
+	// HMAC-SHA256 keyed by the encryption key, so the content can't be confirmed by guessing it
+	SHA256 string
+	// Refs is the number of references to a deduplicated file, zero for files saved without dedup
+	Refs       int
+	UploadDate time.Time
+	// ExpiresAt is the time after which the file is removed by the file reaper, zero if it doesn't expire
+	ExpiresAt time.Time
+	// Store is the name of the FileStore holding the content, empty if it's stored in GridFS chunks
+	Store string
+	// Encoding is the compression and encryption of the content, zero if it's stored as is. Size and SHA256 are
+	// those of the decoded content while MD5 is computed on the encoded content
+	Encoding FileEncoding
+	// Metadata is the user defined metadata of the file
+	Metadata bson.M
+}
+
+// gridFSFile is the document of the files collection
+type gridFSFile struct {
+	ID          interface{} `bson:"_id"`
+	Filename    string      `bson:"filename"`
+	ContentType string      `bson:"contentType,omitempty"`
+	Length      int64       `bson:"length"`
+	ChunkSize   int         `bson:"chunkSize"`
+	MD5         string      `bson:"md5,omitempty"`
+	UploadDate  time.Time   `bson:"uploadDate"`
+	Metadata    bson.M      `bson:"metadata,omitempty"`
+}
+
+// store returns the name of the FileStore holding the content, empty for GridFS chunks
+func (f gridFSFile) store() string {
+	name, _ := f.Metadata[fileStoreKey].(string)
+	return name
+}
+
+func (f gridFSFile) info() FileInfo {
+	info := FileInfo{
+		ID:          fileID(f.ID),
+		Name:        f.Filename,
+		ContentType: f.ContentType,
+		Size:        f.Length,
+		MD5:         f.MD5,
+		UploadDate:  f.UploadDate,
+		Metadata:    f.Metadata,
+	}
+	if sum, ok := f.Metadata[fileSHA256Key].(string); ok {
+		info.SHA256 = sum
+		delete(info.Metadata, fileSHA256Key)
+	}
+	if refs, ok := f.Metadata[fileRefsKey]; ok {
+		info.Refs = toInt(refs)
+		delete(info.Metadata, fileRefsKey)
+	}
+	if expiresAt, ok := f.Metadata[fileExpiresAtKey].(time.Time); ok {
+		info.ExpiresAt = expiresAt
+		delete(info.Metadata, fileExpiresAtKey)
+	}
+	if store, ok := f.Metadata[fileStoreKey].(string); ok {
+		info.Store = store
+		delete(info.Metadata, fileStoreKey)
+	}
+	if enc, ok := f.encoding(); ok {
+		info.Size = enc.Size
+		info.Encoding = enc.FileEncoding
+		delete(info.Metadata, fileEncodingKey)
+	}
+	if len(info.Metadata) == 0 {
+		info.Metadata = nil
+	}
+	return info
+}
+
+// GridFileReader streams the content of a GridFS file. It implements io.ReadSeekCloser, reading the file
+// chunk by chunk instead of loading it in memory. The content of files kept in a FileStore is read from the store,
+// and encoded content is decoded as it's read.
+type GridFileReader struct {
+	content io.ReadSeekCloser
+	info    FileInfo
+}
+
+// GridFileWriter streams content to a new GridFS file. It implements io.WriteCloser, the content is split
+// in chunks as it's written, so only a few chunks are buffered in memory at any time. The file is saved
+// when Close returns without an error. When the bucket has a Store, the content is streamed to the store
+// instead and the files document is saved on Close.
+type GridFileWriter struct {
+	file      *mgo.GridFile
+	upload    *storeUpload
+	encoder   *encodeWriter
+	encoding  FileEncoding
+	hash      hash.Hash
+	size      int64
+	metadata  bson.M
+	expiresAt time.Time
+	closed    bool
+
+	session *DbSession
+	prefix  string
+	config  BucketConfig
+	id      string
+}
+
+var (
+	_ io.ReadSeekCloser = (*GridFileReader)(nil)
+	_ io.WriteCloser    = (*GridFileWriter)(nil)
+)
+
+// OpenFile opens the GridFS file with the given id for reading. The reader must be closed, and the session must
+// stay open while the file is read.
+//
+// For example:
+//
+//	r, err := session.OpenFile(id, "rex_files")
+//	if err != nil {
+//		return err
+//	}
+//	defer r.Close()
+//	http.ServeContent(w, req, r.Info().Name, r.Info().UploadDate, r)
+func (s *DbSession) OpenFile(id, prefix string) (*GridFileReader, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, errors.New("invalid id")
+	}
+	var f *mgo.GridFile
+	err := s.retry(false, func() (err error) {
+		f, err = s.gridFS(prefix).OpenId(bson.ObjectIdHex(id))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.openContent(prefix, f)
+}
+
+// OpenFileByName opens the latest revision of the GridFS file with the given name for reading. It returns
+// mgo.ErrNotFound if there's no such file
+func (s *DbSession) OpenFileByName(name, prefix string) (*GridFileReader, error) {
+	var f *mgo.GridFile
+	err := s.retry(false, func() (err error) {
+		f, err = s.gridFS(prefix).Open(name)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.openContent(prefix, f)
+}
+
+// openContent returns the reader of the file content, from the GridFS chunks or the FileStore holding it, decoding
+// it if the file is encoded
+func (s *DbSession) openContent(prefix string, f *mgo.GridFile) (*GridFileReader, error) {
+	doc := gridFSDoc(f)
+	info := doc.info()
+	var content io.ReadSeekCloser = f
+	if info.Store != "" {
+		f.Close()
+		store, err := fileStore(info.Store)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = store.Open(storeKey(prefix, info.ID)); err != nil {
+			return nil, err
+		}
+	}
+
+	enc, ok := doc.encoding()
+	if !ok {
+		return &GridFileReader{content: content, info: info}, nil
+	}
+	codec, err := newBlockCodec(enc.FileEncoding, s.db.Config.KeyProvider, info.ID)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	decoder, err := newDecodeReader(content, codec, enc)
+	if err != nil {
+		content.Close()
+		return nil, err
+	}
+	return &GridFileReader{content: decoder, info: info}, nil
+}
+
+// Read reads up to len(p) bytes of the file content
+func (r *GridFileReader) Read(p []byte) (int, error) {
+	return r.content.Read(p)
+}
+
+// Seek sets the offset of the next Read, see io.Seeker
+func (r *GridFileReader) Seek(offset int64, whence int) (int64, error) {
+	return r.content.Seek(offset, whence)
+}
+
+// Close closes the file
+func (r *GridFileReader) Close() error {
+	return r.content.Close()
+}
+
+// Info returns the file details
+func (r *GridFileReader) Info() FileInfo {
+	return r.info
+}
+
+// CreateFile creates a new GridFS file with the given name and content type. The content is saved when the
+// writer is closed, or discarded with Abort.
+//
+// For example:
+//
+//	w, err := session.CreateFile("report.csv", "text/csv", "rex_files")
+//	if err != nil {
+//		return err
+//	}
+//	if err := writeReport(w); err != nil {
+//		w.Abort()
+//		w.Close()
+//		return err
+//	}
+//	return w.Close()
+func (s *DbSession) CreateFile(name, contentType, prefix string) (*GridFileWriter, error) {
+	w := &GridFileWriter{hash: sha256.New(), session: s, prefix: prefix, config: s.bucketConfig(prefix)}
+	w.encoding = w.config.Encoding
+	if w.config.Store != "" {
+		store, err := fileStore(w.config.Store)
+		if err != nil {
+			return nil, err
+		}
+		doc := gridFSFile{ID: bson.NewObjectId(), Filename: name, ContentType: contentType}
+		w.upload = newStoreUpload(store, storeKey(prefix, fileID(doc.ID)), doc)
+		w.id = fileID(doc.ID)
+		return w, nil
+	}
+
+	f, err := s.gridFS(prefix).Create(name)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		f.SetContentType(contentType)
+	}
+	w.file = f
+	w.id = fileID(f.Id())
+	return w, nil
+}
+
+// Write writes the content to the file
+func (w *GridFileWriter) Write(p []byte) (int, error) {
+	dst, err := w.content()
+	if err != nil {
+		return 0, err
+	}
+	n, err := dst.Write(p)
+	w.hash.Write(p[:n])
+	w.size += int64(n)
+	return n, err
+}
+
+// content returns the writer of the content, the encoder of encoded files created on the first write
+func (w *GridFileWriter) content() (io.Writer, error) {
+	var dst io.Writer = w.file
+	if w.upload != nil {
+		dst = w.upload
+	}
+	if w.encoding == (FileEncoding{}) {
+		return dst, nil
+	}
+	if w.encoder == nil {
+		codec, err := newBlockCodec(w.encoding, w.session.db.Config.KeyProvider, w.id)
+		if err != nil {
+			return nil, err
+		}
+		w.encoder = newEncodeWriter(dst, codec, w.encoding, defaultChunkSize)
+		if codec.hashKey != nil {
+			w.hash = hmac.New(sha256.New, codec.hashKey)
+		}
+	}
+	return w.encoder, nil
+}
+
+// SetEncoding sets the compression and encryption of the content, overriding the encoding of the bucket. It must
+// be called before Write, and is ignored once content is written
+func (w *GridFileWriter) SetEncoding(encoding FileEncoding) {
+	if w.encoder == nil && w.size == 0 {
+		w.encoding = encoding
+	}
+}
+
+// SetMetadata sets the user defined metadata saved with the file. It must be called before Close
+func (w *GridFileWriter) SetMetadata(metadata bson.M) {
+	w.metadata = metadata
+}
+
+// SetExpiresAt sets the time after which the file is removed by the file reaper. It must be called before Close
+func (w *GridFileWriter) SetExpiresAt(expiresAt time.Time) {
+	w.expiresAt = expiresAt
+}
+
+// Close flushes the pending chunks and saves the file along with its metadata and SHA-256. The returned error
+// must be checked, as a failure to write any chunk is reported on Close.
+func (w *GridFileWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	if err := w.flushEncoder(); err != nil {
+		w.Abort()
+		w.closed = true
+		if w.upload != nil {
+			w.upload.close()
+		} else {
+			w.file.Close()
+		}
+		return err
+	}
+	w.closed = true
+
+	meta := bson.M{fileSHA256Key: w.SHA256()}
+	for k, v := range w.metadata {
+		switch k {
+		case fileSHA256Key, fileRefsKey, fileExpiresAtKey, fileStoreKey, fileEncodingKey:
+		default:
+			meta[k] = v
+		}
+	}
+	if !w.expiresAt.IsZero() {
+		meta[fileExpiresAtKey] = w.expiresAt
+	}
+	if w.encoder != nil {
+		meta[fileEncodingKey] = w.encoder.encoding.metadata()
+	}
+	if w.upload != nil {
+		return w.closeUpload(meta)
+	}
+
+	if w.config.Dedup {
+		meta[fileRefsKey] = 1
+	}
+	w.file.SetMeta(meta)
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if w.config.Dedup {
+		id, err := w.session.dedupFile(w.prefix, w.file.Id(), w.file.Name(), w.SHA256(), w.file.Size(), w.encoding, w.expiresAt)
+		if err != nil {
+			return err
+		}
+		w.id = fileID(id)
+	}
+	w.pruneRevisions(w.file.Name())
+	return nil
+}
+
+// flushEncoder writes the last block of encoded files. Empty files are encoded too, so they're read with the key
+// like the other files of the bucket
+func (w *GridFileWriter) flushEncoder() error {
+	if _, err := w.content(); err != nil {
+		return err
+	}
+	if w.encoder == nil {
+		return nil
+	}
+	return w.encoder.Close()
+}
+
+// closeUpload completes the upload to the file store and saves the files document pointing to the content
+func (w *GridFileWriter) closeUpload(meta bson.M) error {
+	size, err := w.upload.close()
+	if err != nil {
+		return err
+	}
+
+	doc := w.upload.doc
+	meta[fileStoreKey] = w.config.Store
+	doc.Length = size
+	doc.UploadDate = time.Now()
+	doc.Metadata = meta
+	files := w.session.gridFS(w.prefix).Files
+	if err := w.session.retry(true, func() error { return files.Insert(doc) }); err != nil {
+		// the insert may have been applied before its result was lost, its retry then fails with a duplicate key
+		n, findErr := files.Find(bson.M{"_id": doc.ID, "metadata." + fileStoreKey: w.config.Store}).Count()
+		switch {
+		case findErr != nil:
+			log.Printf("[GMGO] Error checking the file of upload %s, its content is kept. Error: %s\n", w.upload.key, findErr)
+			return err
+		case n == 0:
+			if delErr := w.upload.store.Delete(w.upload.key); delErr != nil {
+				log.Printf("[GMGO] Error removing content %s of failed upload. Error: %s\n", w.upload.key, delErr)
+			}
+			return err
+		}
+	}
+	w.pruneRevisions(doc.Filename)
+	return nil
+}
+
+// pruneRevisions deletes the revisions beyond the number kept by the bucket, if any
+func (w *GridFileWriter) pruneRevisions(name string) {
+	if w.config.Revisions <= 0 {
+		return
+	}
+	if err := w.session.pruneRevisions(name, w.prefix, w.config.Revisions, w.id); err != nil {
+		log.Printf("[GMGO] Error pruning revisions of %s in %s. Error: %s\n", name, w.prefix, err)
+	}
+}
+
+// Abort discards the file. The chunks already written are removed when the writer is closed.
+func (w *GridFileWriter) Abort() {
+	if w.closed {
+		return
+	}
+	if w.upload != nil {
+		w.upload.abort()
+	} else {
+		w.file.Abort()
+	}
+}
+
+// SHA256 returns the hex SHA-256 of the content written so far, the keyed HMAC-SHA256 for encrypted files
+func (w *GridFileWriter) SHA256() string {
+	return hex.EncodeToString(w.hash.Sum(nil))
+}
+
+// ID returns the hex id of the new file. With dedup, it's the id of the existing file with the same content
+// once the writer is closed
+func (w *GridFileWriter) ID() string {
+	return w.id
+}
+
+// SaveFromReader streams the content of the reader into a new GridFS file and returns its id and size. Nothing
+// is saved if reading fails.
+func (s *DbSession) SaveFromReader(name, contentType, prefix string, r io.Reader) (string, int64, error) {
+	w, err := s.CreateFile(name, contentType, prefix)
+	if err != nil {
+		return "", 0, err
+	}
+	n, err := io.Copy(w, r)
+	if err != nil {
+		w.Abort()
+		w.Close()
+		return "", n, err
+	}
+	if err := w.Close(); err != nil {
+		return "", n, err
+	}
+	return w.ID(), n, nil
+}
+
+// CopyTo streams the content of the GridFS file with the given id to the writer and returns the number of bytes
+// copied. It fails with io.ErrUnexpectedEOF if the file is shorter than its recorded length.
+func (s *DbSession) CopyTo(id, prefix string, w io.Writer) (int64, error) {
+	r, err := s.OpenFile(id, prefix)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	size := r.info.Size
+	n, err := io.Copy(w, r)
+	if err != nil {
+		return n, err
+	}
+	if n != size {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, r.Close()
+}
+
+// ListFiles returns the GridFS files matching the query, newest first unless SortBy is set. The query and sort
+// fields are the fields of the files collection, e.g. filename, contentType, length, uploadDate or metadata.<key>.
+// Skip and Limit of the config page through the files.
+//
+// For example:
+//
+//	files, err := session.ListFiles("rex_files", gmgo.Q{"metadata.owner": userID}, gmgo.IteratorConfig{Limit: 20})
+func (s *DbSession) ListFiles(prefix string, query Q, cfg IteratorConfig) ([]FileInfo, error) {
+	if len(cfg.SortBy) == 0 {
+		// uploadDate only has millisecond precision, the id orders the revisions uploaded within the same millisecond
+		cfg.SortBy = []string{"-uploadDate", "-_id"}
+	}
+
+	var docs []gridFSFile
+	started := time.Now()
+	err := s.retry(false, func() error {
+		return cfg.apply(s.gridFS(prefix).Files.Find(query)).All(&docs)
+	})
+	s.observeQuery("ListFiles", prefix+".files", query, &cfg, time.Since(started))
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]FileInfo, len(docs))
+	for i, doc := range docs {
+		files[i] = doc.info()
+	}
+	return files, nil
+}
+
+// FindFileByName returns the latest revision of the file with the given name. It returns mgo.ErrNotFound if
+// there's no such file
+func (s *DbSession) FindFileByName(name, prefix string) (FileInfo, error) {
+	files, err := s.ListFiles(prefix, Q{"filename": name}, IteratorConfig{Limit: 1})
+	if err != nil {
+		return FileInfo{}, err
+	}
+	if len(files) == 0 {
+		return FileInfo{}, mgo.ErrNotFound
+	}
+	return files[0], nil
+}
+
+// ListRevisions returns all the revisions of the file with the given name, newest first
+func (s *DbSession) ListRevisions(name, prefix string) ([]FileInfo, error) {
+	return s.ListFiles(prefix, Q{"filename": name}, IteratorConfig{})
+}
+
+// FindFileRevisions returns all the revisions of the file with the given name, newest first.
+//
+// Deprecated: use ListRevisions
+func (s *DbSession) FindFileRevisions(name, prefix string) ([]FileInfo, error) {
+	return s.ListRevisions(name, prefix)
+}
+
+// RestoreRevision makes the revision with the given id the latest revision of its file, by saving a copy of it
+// with the same name, content type and metadata. The revision itself is left as is. It returns the id of the copy
+func (s *DbSession) RestoreRevision(id, prefix string) (string, error) {
+	r, err := s.OpenFile(id, prefix)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	info := r.Info()
+	w, err := s.CreateFile(info.Name, info.ContentType, prefix)
+	if err != nil {
+		return "", err
+	}
+	w.SetMetadata(info.Metadata)
+	w.SetExpiresAt(info.ExpiresAt)
+	if info.Encoding != (FileEncoding{}) {
+		w.SetEncoding(info.Encoding)
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Abort()
+		w.Close()
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return w.ID(), nil
+}
+
+// pruneRevisions deletes the revisions of the file beyond the newest keep revisions, except the given id
+func (s *DbSession) pruneRevisions(name, prefix string, keep int, except string) error {
+	revisions, err := s.ListRevisions(name, prefix)
+	if err != nil {
+		return err
+	}
+	if len(revisions) <= keep {
+		return nil
+	}
+	for _, rev := range revisions[keep:] {
+		if rev.ID == except {
+			continue
+		}
+		if err := s.DeleteFile(rev.ID, prefix); err != nil && err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// DeleteFile removes the GridFS file with the given id along with its chunks. A deduplicated file referenced
+// more than once only has its reference count decremented. It returns mgo.ErrNotFound if there's no such file
+func (s *DbSession) DeleteFile(id, prefix string) error {
+	if !bson.IsObjectIdHex(id) {
+		return errors.New("invalid id")
+	}
+	oid := bson.ObjectIdHex(id)
+	gfs := s.gridFS(prefix)
+	last := bson.M{"_id": oid, "metadata." + fileRefsKey: bson.M{"$not": bson.M{"$gt": 1}}}
+	for {
+		// the decrement isn't retried, a retry after the server applied it would release another reference
+		err := gfs.Files.Update(bson.M{"_id": oid, "metadata." + fileRefsKey: bson.M{"$gt": 1}},
+			bson.M{"$inc": bson.M{"metadata." + fileRefsKey: -1}})
+		if err != mgo.ErrNotFound {
+			return err
+		}
+
+		// last reference, the file is removed unless a new reference was added meanwhile
+		var file gridFSFile
+		err = s.retry(false, func() error { return gfs.Files.Find(last).One(&file) })
+		if err == mgo.ErrNotFound {
+			n, err := gfs.Files.FindId(oid).Count()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				return mgo.ErrNotFound
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = s.retry(true, func() error { return gfs.Files.Remove(last) })
+		if err == mgo.ErrNotFound {
+			if n, _ := gfs.Files.FindId(oid).Count(); n > 0 {
+				continue
+			}
+			// removed by a retry of the remove or a concurrent delete, removing the content again is harmless
+		} else if err != nil {
+			return err
+		}
+		return s.retry(true, func() error { return s.removeContent(prefix, file) })
+	}
+}
+
+// removeContent removes the content of the removed file, the GridFS chunks or the object in its FileStore
+func (s *DbSession) removeContent(prefix string, file gridFSFile) error {
+	if name := file.store(); name != "" {
+		store, err := fileStore(name)
+		if err != nil {
+			return err
+		}
+		return store.Delete(storeKey(prefix, fileID(file.ID)))
+	}
+	_, err := s.gridFS(prefix).Chunks.RemoveAll(bson.M{"files_id": file.ID})
+	return err
+}
+
+// dedupFile looks for the oldest deduplicated file with the same content as the new file. If it's another file,
+// its reference count is incremented, its expiry, if any, extended to the expiry of the new file, and the new file
+// is removed. A file with the same name becomes the latest revision of the name. Concurrent uploads of the same content all resolve to the oldest file. Only files with the same encoding
+// are merged, so content isn't kept unencrypted or under another key than requested. It returns the id of the file
+// to use.
+func (s *DbSession) dedupFile(prefix string, newID interface{}, name, sum string, size int64, enc FileEncoding, expiresAt time.Time) (interface{}, error) {
+	gfs := s.gridFS(prefix)
+	index := mgo.Index{Key: []string{"metadata." + fileSHA256Key, "length"}, Background: true}
+	if err := gfs.Files.EnsureIndex(index); err != nil {
+		return nil, err
+	}
+	query := bson.M{"metadata." + fileSHA256Key: sum, "length": size, "metadata." + fileRefsKey: bson.M{"$gte": 1}}
+	// a nil value matches files without the field
+	query["metadata."+fileEncodingKey+".compression"] = nilIfEmpty(enc.Compression)
+	query["metadata."+fileEncodingKey+".keyId"] = nilIfEmpty(enc.KeyID)
+
+	for {
+		// sorted by id, as the upload date changes when a file becomes the latest revision again
+		var oldest gridFSFile
+		err := s.retry(false, func() error {
+			return gfs.Files.Find(query).Sort("_id").Select(bson.M{"_id": 1, "filename": 1}).One(&oldest)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if oldest.ID == newID {
+			return newID, nil
+		}
+
+		update := bson.M{"$inc": bson.M{"metadata." + fileRefsKey: 1}}
+		if oldest.Filename == name {
+			// content saved again, e.g. by RestoreRevision, becomes the latest revision of the file
+			update["$set"] = bson.M{"uploadDate": bson.Now()}
+		}
+		// the file expires once all its references do: an expiry is extended, but never added to a file
+		// without one
+		selector := bson.M{"_id": oldest.ID, "metadata." + fileRefsKey: bson.M{"$gte": 1}}
+		if expiresAt.IsZero() {
+			update["$unset"] = bson.M{"metadata." + fileExpiresAtKey: ""}
+		} else {
+			selector["metadata."+fileExpiresAtKey] = bson.M{"$exists": true}
+			update["$max"] = bson.M{"metadata." + fileExpiresAtKey: expiresAt}
+		}
+		// the increment isn't retried, a retry after the server applied it would add a reference never released
+		err = gfs.Files.Update(selector, update)
+		if err == mgo.ErrNotFound && !expiresAt.IsZero() {
+			selector["metadata."+fileExpiresAtKey] = bson.M{"$exists": false}
+			delete(update, "$max")
+			err = gfs.Files.Update(selector, update)
+		}
+		if err == mgo.ErrNotFound {
+			// the oldest file was deleted meanwhile, look again
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if err := s.retry(true, func() error { return gfs.RemoveId(newID) }); err != nil {
+			log.Printf("[GMGO] Error removing duplicate file %v in %s. Error: %s\n", newID, prefix, err)
+		}
+		return oldest.ID, nil
+	}
+}
+
+// RenameFile changes the name of the GridFS file with the given id. It returns mgo.ErrNotFound if there's no
+// such file
+func (s *DbSession) RenameFile(id, name, prefix string) error {
+	if !bson.IsObjectIdHex(id) {
+		return errors.New("invalid id")
+	}
+	return s.retry(true, func() error {
+		return s.gridFS(prefix).Files.UpdateId(bson.ObjectIdHex(id), bson.M{"$set": bson.M{"filename": name}})
+	})
+}
+
+// gridFSDoc returns the files document of the open file
+func gridFSDoc(f *mgo.GridFile) gridFSFile {
+	doc := gridFSFile{
+		ID:          f.Id(),
+		Filename:    f.Name(),
+		ContentType: f.ContentType(),
+		Length:      f.Size(),
+		MD5:         f.MD5(),
+		UploadDate:  f.UploadDate(),
+	}
+	f.GetMeta(&doc.Metadata)
+	return doc
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// fileID returns the hex of ObjectId file ids, other ids are formatted as is
+func fileID(id interface{}) string {
+	if oid, ok := id.(bson.ObjectId); ok {
+		return oid.Hex()
+	}
+	return fmt.Sprint(id)
+}
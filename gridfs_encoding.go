@@ -0,0 +1,459 @@
+package gmgo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// CompressionGzip compresses the content blocks with gzip
+	CompressionGzip = "gzip"
+	// CompressionZstd compresses the content blocks with zstd
+	CompressionZstd = "zstd"
+)
+
+// fileEncodingKey is the metadata field holding the encoding of the file content
+const fileEncodingKey = "encoding"
+
+// blockHeaderSize is the size of the header of an encoded block, the length of the encoded block and of its content
+const blockHeaderSize = 8
+
+// maxBlockSize is the largest block decoded. Blocks have the size of a GridFS chunk, which must fit in a document
+const maxBlockSize = 16 << 20
+
+// FileEncoding is the client side compression and encryption of the content of a GridFS file. The content is
+// split in blocks of the chunk size, each block being compressed then encrypted with AES-GCM, so the file can be
+// read from any offset without decoding it whole. The encoding and key id are recorded in the file metadata and
+// files are decoded transparently when read, using the KeyProvider of the DbConfig.
+type FileEncoding struct {
+	// Compression is CompressionGzip or CompressionZstd. Empty doesn't compress
+	Compression string
+	// KeyID is the id of the KeyProvider key encrypting the content. Empty doesn't encrypt
+	KeyID string
+}
+
+// KeyProvider returns the encryption keys of the GridFS files by id. Keys must stay available as long as files
+// encrypted with them exist, so rotating keys means adding a new key id for new files.
+type KeyProvider interface {
+	// Key returns the AES key with the given id, 16, 24 or 32 bytes long
+	Key(id string) ([]byte, error)
+}
+
+// StaticKeys is a KeyProvider holding the keys in memory, by id
+type StaticKeys map[string][]byte
+
+// Key returns the key with the given id
+func (k StaticKeys) Key(id string) ([]byte, error) {
+	key, ok := k[id]
+	if !ok {
+		return nil, fmt.Errorf("encryption key %s not found", id)
+	}
+	return key, nil
+}
+
+// fileEncoding is the encoding recorded in the metadata of an encoded file
+type fileEncoding struct {
+	FileEncoding
+	// Size is the length of the decoded content, the length of the file being the length of the encoded content
+	Size      int64
+	BlockSize int
+}
+
+func (e fileEncoding) metadata() bson.M {
+	meta := bson.M{"size": e.Size, "blockSize": e.BlockSize}
+	if e.Compression != "" {
+		meta["compression"] = e.Compression
+	}
+	if e.KeyID != "" {
+		meta["keyId"] = e.KeyID
+	}
+	return meta
+}
+
+// encoding returns the encoding of the file content, false if the content is stored as is
+func (f gridFSFile) encoding() (fileEncoding, bool) {
+	meta, ok := f.Metadata[fileEncodingKey].(bson.M)
+	if !ok {
+		return fileEncoding{}, false
+	}
+	enc := fileEncoding{Size: int64(toInt(meta["size"])), BlockSize: toInt(meta["blockSize"])}
+	enc.Compression, _ = meta["compression"].(string)
+	enc.KeyID, _ = meta["keyId"].(string)
+	return enc, true
+}
+
+// blockCodec compresses and encrypts the blocks of a file. The file id, block index and whether it's the last block
+// are authenticated with each encrypted block, so blocks can't be reordered, moved to another file or dropped from
+// the end of the file
+type blockCodec struct {
+	compression string
+	aead        cipher.AEAD
+	fileID      string
+	// hashKey keys the HMAC-SHA256 of the content of encrypted files, which is stored in place of its SHA-256
+	hashKey []byte
+}
+
+func newBlockCodec(enc FileEncoding, keys KeyProvider, fileID string) (*blockCodec, error) {
+	switch enc.Compression {
+	case "", CompressionGzip, CompressionZstd:
+	default:
+		return nil, fmt.Errorf("unknown compression %s", enc.Compression)
+	}
+	codec := &blockCodec{compression: enc.Compression, fileID: fileID}
+	if enc.KeyID == "" {
+		return codec, nil
+	}
+
+	if keys == nil {
+		return nil, errors.New("no KeyProvider configured to encrypt files")
+	}
+	key, err := keys.Key(enc.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %s: %s", enc.KeyID, err)
+	}
+	if codec.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	// derived so the encryption key itself isn't used for anything but encryption
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("gmgo content hash"))
+	codec.hashKey = mac.Sum(nil)
+	return codec, nil
+}
+
+// additionalData returns the data authenticated with the block
+func (c *blockCodec) additionalData(index int64, last bool) []byte {
+	ad := make([]byte, len(c.fileID)+9)
+	copy(ad, c.fileID)
+	binary.BigEndian.PutUint64(ad[len(c.fileID):], uint64(index))
+	if last {
+		ad[len(ad)-1] = 1
+	}
+	return ad
+}
+
+// seal compresses then encrypts the block, prefixing the random nonce
+func (c *blockCodec) seal(index int64, last bool, plain []byte) ([]byte, error) {
+	data, err := compressBlock(c.compression, plain)
+	if err != nil {
+		return nil, err
+	}
+	if c.aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(data)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, data, c.additionalData(index, last)), nil
+}
+
+// open decrypts then decompresses the block, which must have the given size once decoded
+func (c *blockCodec) open(index int64, last bool, data []byte, size int) ([]byte, error) {
+	if c.aead != nil {
+		if len(data) < c.aead.NonceSize() {
+			return nil, fmt.Errorf("block %d is corrupted", index)
+		}
+		nonce := data[:c.aead.NonceSize()]
+		var err error
+		data, err = c.aead.Open(nil, nonce, data[len(nonce):], c.additionalData(index, last))
+		if err != nil {
+			return nil, fmt.Errorf("block %d can't be decrypted: %s", index, err)
+		}
+	}
+	plain, err := decompressBlock(c.compression, data, size)
+	if err != nil {
+		return nil, fmt.Errorf("block %d can't be decompressed: %s", index, err)
+	}
+	if len(plain) != size {
+		return nil, fmt.Errorf("block %d has %d bytes, expected %d", index, len(plain), size)
+	}
+	return plain, nil
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// zstdCodec returns the shared zstd encoder and decoder, which are safe for concurrent EncodeAll and DecodeAll.
+// The decoder doesn't decode more than a block, so corrupted blocks can't exhaust the memory
+func zstdCodec() (*zstd.Encoder, *zstd.Decoder) {
+	zstdOnce.Do(func() {
+		zstdEncoder, _ = zstd.NewWriter(nil)
+		zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxBlockSize))
+	})
+	return zstdEncoder, zstdDecoder
+}
+
+func compressBlock(compression string, plain []byte) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		if _, err := gz.Write(plain); err != nil {
+			return nil, err
+		}
+		if err := gz.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		enc, _ := zstdCodec()
+		return enc.EncodeAll(plain, nil), nil
+	}
+	return plain, nil
+}
+
+// decompressBlock decompresses the block, reading at most one byte more than its expected size with gzip and
+// at most maxBlockSize with zstd
+func decompressBlock(compression string, data []byte, size int) ([]byte, error) {
+	switch compression {
+	case CompressionGzip:
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(io.LimitReader(gz, int64(size)+1))
+	case CompressionZstd:
+		_, dec := zstdCodec()
+		return dec.DecodeAll(data, make([]byte, 0, size))
+	}
+	return data, nil
+}
+
+// encodeWriter splits the content in blocks written encoded to w, each one prefixed by its header. A full block is
+// only written once more content follows, as the last block is flagged. Empty content is written as one empty block.
+type encodeWriter struct {
+	w        io.Writer
+	codec    *blockCodec
+	encoding fileEncoding
+	buf      []byte
+	index    int64
+	err      error
+}
+
+func newEncodeWriter(w io.Writer, codec *blockCodec, enc FileEncoding, blockSize int) *encodeWriter {
+	return &encodeWriter{
+		w:        w,
+		codec:    codec,
+		encoding: fileEncoding{FileEncoding: enc, BlockSize: blockSize},
+		buf:      make([]byte, 0, blockSize),
+	}
+}
+
+func (e *encodeWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 && e.err == nil {
+		if len(e.buf) == cap(e.buf) {
+			e.flush(false)
+			continue
+		}
+		n := copy(e.buf[len(e.buf):cap(e.buf)], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+		e.encoding.Size += int64(n)
+	}
+	return written, e.err
+}
+
+// flush writes the pending block
+func (e *encodeWriter) flush(last bool) {
+	if e.err != nil {
+		return
+	}
+	data, err := e.codec.seal(e.index, last, e.buf)
+	if err != nil {
+		e.err = err
+		return
+	}
+	header := make([]byte, blockHeaderSize)
+	binary.BigEndian.PutUint32(header, uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], uint32(len(e.buf)))
+	if _, err := e.w.Write(header); err != nil {
+		e.err = err
+		return
+	}
+	if _, err := e.w.Write(data); err != nil {
+		e.err = err
+		return
+	}
+	e.index++
+	e.buf = e.buf[:0]
+}
+
+// Close writes the last block. It doesn't close the underlying writer
+func (e *encodeWriter) Close() error {
+	e.flush(true)
+	return e.err
+}
+
+// decodeReader reads the decoded content of an encoded file. As all the blocks but the last have the block size,
+// the block holding an offset is known up front, and seeking only reads the headers of the blocks before it
+type decodeReader struct {
+	r        io.ReadSeekCloser
+	codec    *blockCodec
+	encoding fileEncoding
+	offset   int64
+	// blocks are the offsets of the encoded blocks found so far
+	blocks []int64
+	// pos is the offset of r
+	pos   int64
+	block []byte
+	index int64
+}
+
+func newDecodeReader(r io.ReadSeekCloser, codec *blockCodec, enc fileEncoding) (*decodeReader, error) {
+	if enc.BlockSize <= 0 || enc.BlockSize > maxBlockSize || enc.Size < 0 {
+		return nil, errors.New("invalid file encoding")
+	}
+	return &decodeReader{r: r, codec: codec, encoding: enc, blocks: []int64{0}, index: -1}, nil
+}
+
+func (d *decodeReader) Read(p []byte) (int, error) {
+	if d.offset >= d.encoding.Size {
+		// the empty block of empty content is read to check it's the whole content
+		if d.encoding.Size == 0 && d.index != 0 {
+			if err := d.load(0); err != nil {
+				return 0, err
+			}
+		}
+		return 0, io.EOF
+	}
+	index := d.offset / int64(d.encoding.BlockSize)
+	if index != d.index {
+		if err := d.load(index); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.block[d.offset-index*int64(d.encoding.BlockSize):])
+	d.offset += int64(n)
+	return n, nil
+}
+
+// load reads and decodes the block with the given index
+func (d *decodeReader) load(index int64) error {
+	d.block, d.index = nil, -1
+	i := index
+	if last := int64(len(d.blocks) - 1); i > last {
+		i = last
+	}
+	if err := d.seek(d.blocks[i]); err != nil {
+		return err
+	}
+
+	for ; ; i++ {
+		length, size, err := d.readHeader(i)
+		if err != nil {
+			return err
+		}
+		next := d.pos + int64(length)
+		if i+1 == int64(len(d.blocks)) {
+			d.blocks = append(d.blocks, next)
+		}
+		if i < index {
+			if err := d.seek(next); err != nil {
+				return err
+			}
+			continue
+		}
+
+		data := make([]byte, length)
+		if _, err := io.ReadFull(d.r, data); err != nil {
+			return unexpectedEOF(err)
+		}
+		d.pos = next
+		if d.block, err = d.codec.open(index, index == d.lastBlock(), data, size); err != nil {
+			return err
+		}
+		d.index = index
+		return nil
+	}
+}
+
+// lastBlock returns the index of the last block
+func (d *decodeReader) lastBlock() int64 {
+	if d.encoding.Size == 0 {
+		return 0
+	}
+	return (d.encoding.Size - 1) / int64(d.encoding.BlockSize)
+}
+
+// readHeader reads the header of the block and checks its decoded size
+func (d *decodeReader) readHeader(index int64) (int, int, error) {
+	header := make([]byte, blockHeaderSize)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return 0, 0, unexpectedEOF(err)
+	}
+	d.pos += blockHeaderSize
+	length := int(binary.BigEndian.Uint32(header))
+	size := int(binary.BigEndian.Uint32(header[4:]))
+
+	blockSize := int64(d.encoding.BlockSize)
+	expected := d.encoding.Size - index*blockSize
+	if expected > blockSize {
+		expected = blockSize
+	}
+	// compressed blocks may be slightly larger than their content
+	if int64(size) != expected || length > d.encoding.BlockSize+d.encoding.BlockSize/16+1024 {
+		return 0, 0, fmt.Errorf("block %d is corrupted", index)
+	}
+	return length, size, nil
+}
+
+func (d *decodeReader) seek(pos int64) error {
+	if pos == d.pos {
+		return nil
+	}
+	if _, err := d.r.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+	d.pos = pos
+	return nil
+}
+
+// Seek sets the offset of the next Read in the decoded content
+func (d *decodeReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.encoding.Size
+	}
+	if offset < 0 {
+		return d.offset, errors.New("seek to negative offset")
+	}
+	d.offset = offset
+	return offset, nil
+}
+
+func (d *decodeReader) Close() error {
+	return d.r.Close()
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package gmgo
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+var testKeys = StaticKeys{"k1": bytes.Repeat([]byte{1}, 32), "k2": bytes.Repeat([]byte{2}, 16)}
+
+type memFile struct {
+	*bytes.Reader
+}
+
+func (memFile) Close() error { return nil }
+
+// encodeContent encodes the content as a file with the given id and returns the encoded content and encoding
+func encodeContent(t *testing.T, enc FileEncoding, id string, content []byte, blockSize int) ([]byte, fileEncoding) {
+	codec, err := newBlockCodec(enc, testKeys, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := newEncodeWriter(&buf, codec, enc, blockSize)
+	// odd sized writes spanning blocks
+	for p := content; len(p) > 0; {
+		n := 700
+		if n > len(p) {
+			n = len(p)
+		}
+		if _, err := w.Write(p[:n]); err != nil {
+			t.Fatal(err)
+		}
+		p = p[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), w.encoding
+}
+
+func decodeContent(enc fileEncoding, id string, encoded []byte) (*decodeReader, error) {
+	codec, err := newBlockCodec(enc.FileEncoding, testKeys, id)
+	if err != nil {
+		return nil, err
+	}
+	return newDecodeReader(memFile{bytes.NewReader(encoded)}, codec, enc)
+}
+
+func TestFileEncodingRoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat("some compressible content ", 1000))
+	encodings := []FileEncoding{
+		{Compression: CompressionGzip},
+		{Compression: CompressionZstd},
+		{KeyID: "k1"},
+		{Compression: CompressionGzip, KeyID: "k2"},
+		{Compression: CompressionZstd, KeyID: "k1"},
+	}
+	for _, enc := range encodings {
+		encoded, meta := encodeContent(t, enc, "abc", content, 4096)
+		if meta.Size != int64(len(content)) || meta.BlockSize != 4096 {
+			t.Errorf("%+v: unexpected encoding %+v", enc, meta)
+		}
+		if enc.KeyID != "" && bytes.Contains(encoded, []byte("compressible")) {
+			t.Errorf("%+v: content not encrypted", enc)
+		}
+		if enc.Compression != "" && len(encoded) >= len(content) {
+			t.Errorf("%+v: content not compressed, %d bytes", enc, len(encoded))
+		}
+
+		r, err := decodeContent(meta, "abc", encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%+v: %s", enc, err)
+		}
+		if !bytes.Equal(decoded, content) {
+			t.Errorf("%+v: decoded content doesn't match", enc)
+		}
+	}
+}
+
+func TestFileEncodingSeek(t *testing.T) {
+	content := make([]byte, 10000)
+	rand.New(rand.NewSource(1)).Read(content)
+	encoded, meta := encodeContent(t, FileEncoding{Compression: CompressionGzip, KeyID: "k1"}, "abc", content, 1024)
+
+	r, err := decodeContent(meta, "abc", encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := r.Seek(0, io.SeekEnd); size != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), size)
+	}
+	for _, offset := range []int64{9000, 10, 5000, 1023, 1024, 0, 9999} {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 100)
+		n, err := io.ReadFull(r, b)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatalf("Reading at %d: %s", offset, err)
+		}
+		if !bytes.Equal(b[:n], content[offset:offset+int64(n)]) {
+			t.Errorf("Content at %d doesn't match", offset)
+		}
+	}
+}
+
+func TestFileEncodingTampering(t *testing.T) {
+	content := []byte(strings.Repeat("secret ", 500))
+	enc := FileEncoding{KeyID: "k1"}
+	encoded, meta := encodeContent(t, enc, "abc", content, 1024)
+
+	// blocks are bound to their file
+	r, _ := decodeContent(meta, "other", encoded)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected decryption error for another file id")
+	}
+
+	// blocks are authenticated
+	corrupted := append([]byte{}, encoded...)
+	corrupted[len(corrupted)-1] ^= 1
+	r, _ = decodeContent(meta, "abc", corrupted)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected decryption error for corrupted content")
+	}
+
+	// truncated content
+	r, _ = decodeContent(meta, "abc", encoded[:len(encoded)-100])
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected error for truncated content")
+	}
+
+	// lowered size, to a block boundary and to empty content
+	for _, size := range []int64{2048, 0} {
+		lowered := meta
+		lowered.Size = size
+		r, _ = decodeContent(lowered, "abc", encoded)
+		if _, err := ioutil.ReadAll(r); err == nil {
+			t.Errorf("Expected error for content truncated to %d bytes", size)
+		}
+	}
+
+	// wrong key
+	meta.KeyID = "k2"
+	r, _ = decodeContent(meta, "abc", encoded)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected decryption error with another key")
+	}
+}
+
+func TestEmptyFileEncoding(t *testing.T) {
+	enc := FileEncoding{Compression: CompressionZstd, KeyID: "k1"}
+	encoded, meta := encodeContent(t, enc, "abc", nil, 1024)
+	if meta.Size != 0 || len(encoded) == 0 {
+		t.Fatalf("Expected an empty block, got %d bytes %+v", len(encoded), meta)
+	}
+	r, _ := decodeContent(meta, "abc", encoded)
+	if decoded, err := ioutil.ReadAll(r); err != nil || len(decoded) != 0 {
+		t.Errorf("Expected empty content, got %d bytes %v", len(decoded), err)
+	}
+	r, _ = decodeContent(meta, "abc", nil)
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("Expected error for missing empty block")
+	}
+}
+
+func TestDecompressBlockLimit(t *testing.T) {
+	enc, _ := zstdCodec()
+	bomb := enc.EncodeAll(make([]byte, maxBlockSize+1), nil)
+	if _, err := decompressBlock(CompressionZstd, bomb, 1024); err == nil {
+		t.Error("Expected error for a block decoding to more than maxBlockSize")
+	}
+}
+
+func TestContentHashKey(t *testing.T) {
+	c1, _ := newBlockCodec(FileEncoding{KeyID: "k1"}, testKeys, "abc")
+	c2, _ := newBlockCodec(FileEncoding{Compression: CompressionGzip, KeyID: "k1"}, testKeys, "def")
+	if len(c1.hashKey) == 0 || !bytes.Equal(c1.hashKey, c2.hashKey) {
+		t.Error("Expected the same hash key for the files encrypted with a key, for dedup")
+	}
+	if bytes.Equal(c1.hashKey, testKeys["k1"]) {
+		t.Error("Expected the hash key to be derived from the encryption key")
+	}
+	if c3, _ := newBlockCodec(FileEncoding{KeyID: "k2"}, testKeys, "abc"); bytes.Equal(c1.hashKey, c3.hashKey) {
+		t.Error("Expected another hash key for another encryption key")
+	}
+	if plain, _ := newBlockCodec(FileEncoding{Compression: CompressionZstd}, testKeys, "abc"); plain.hashKey != nil {
+		t.Error("Expected unencrypted files to keep their SHA-256")
+	}
+}
+
+func TestBlockCodecErrors(t *testing.T) {
+	if _, err := newBlockCodec(FileEncoding{KeyID: "missing"}, testKeys, "abc"); err == nil {
+		t.Error("Expected error for unknown key")
+	}
+	if _, err := newBlockCodec(FileEncoding{KeyID: "k1"}, nil, "abc"); err == nil {
+		t.Error("Expected error without key provider")
+	}
+	if _, err := newBlockCodec(FileEncoding{Compression: "lz4"}, testKeys, "abc"); err == nil {
+		t.Error("Expected error for unknown compression")
+	}
+	if _, err := newBlockCodec(FileEncoding{KeyID: "bad"}, StaticKeys{"bad": []byte("short")}, "abc"); err == nil {
+		t.Error("Expected error for invalid key size")
+	}
+}
+
+func TestFileEncodingMetadata(t *testing.T) {
+	enc := fileEncoding{FileEncoding: FileEncoding{Compression: CompressionZstd, KeyID: "k1"}, Size: 1 << 20, BlockSize: 1024}
+	file := gridFSFile{Length: 1000, Metadata: map[string]interface{}{fileEncodingKey: enc.metadata(), "owner": "x"}}
+	got, ok := file.encoding()
+	if !ok || got != enc {
+		t.Errorf("Expected %+v, got %+v", enc, got)
+	}
+
+	info := file.info()
+	if info.Size != enc.Size || info.Encoding != enc.FileEncoding || len(info.Metadata) != 1 {
+		t.Errorf("Unexpected file info %+v", info)
+	}
+	if _, ok := (gridFSFile{}).encoding(); ok {
+		t.Error("Expected plain file")
+	}
+}
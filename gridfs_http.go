@@ -0,0 +1,77 @@
+package gmgo
+
+import (
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// FileHandler returns the http.Handler serving the files of the GridFS bucket with the given prefix. The path
+// of the request URL, without the leading slash, is the file id if it's an ObjectId hex, otherwise the file name,
+// in which case the latest revision is served. The content is streamed from GridFS chunk by chunk with the
+// Content-Type, Content-Length, ETag and Last-Modified headers. Conditional requests (If-None-Match,
+// If-Modified-Since) and range requests are supported, see http.ServeContent.
+//
+// For example:
+//
+//	db, _ := gmgo.Get("users")
+//	http.Handle("/files/", http.StripPrefix("/files/", db.FileHandler("rex_files")))
+func (db Db) FileHandler(prefix string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+
+		path := strings.TrimPrefix(r.URL.Path, "/")
+		if path == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		session := db.Session()
+		defer session.Close()
+
+		var file *GridFileReader
+		var err error
+		if bson.IsObjectIdHex(path) {
+			file, err = session.OpenFile(path, prefix)
+		} else {
+			file, err = session.OpenFileByName(path, prefix)
+		}
+		if err == mgo.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			log.Printf("[GMGO] Error opening file %s in %s. Error: %s\n", path, prefix, err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		defer file.Close()
+
+		info := file.Info()
+		if info.ContentType != "" {
+			w.Header().Set("Content-Type", info.ContentType)
+		}
+		w.Header().Set("ETag", fileETag(info))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		http.ServeContent(w, r, info.Name, info.UploadDate, file)
+	})
+}
+
+// fileETag returns the strong ETag of the file content, using the SHA-256 computed by gmgo or the MD5 computed by
+// the server. Files without either use their id, which is unique per revision.
+func fileETag(info FileInfo) string {
+	switch {
+	case info.SHA256 != "":
+		return `"` + info.SHA256 + `"`
+	case info.MD5 != "":
+		return `"` + info.MD5 + `"`
+	}
+	return `"` + info.ID + `"`
+}
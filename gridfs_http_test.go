@@ -0,0 +1,62 @@
+package gmgo
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFileHandlerMethodNotAllowed(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Db{}.FileHandler("rex_files").ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/abc", nil))
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("Expected 405, got %d", rec.Code)
+	}
+}
+
+func TestFileETag(t *testing.T) {
+	if etag := fileETag(FileInfo{ID: "1", MD5: "m", SHA256: "s"}); etag != `"s"` {
+		t.Errorf("Expected SHA-256 ETag, got %s", etag)
+	}
+	if etag := fileETag(FileInfo{ID: "1", MD5: "m"}); etag != `"m"` {
+		t.Errorf("Expected MD5 ETag, got %s", etag)
+	}
+	if etag := fileETag(FileInfo{ID: "1"}); etag != `"1"` {
+		t.Errorf("Expected id ETag, got %s", etag)
+	}
+}
+
+func xxTestFileHandler(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	id, _, err := session.SaveFromReader("hello.txt", "text/plain", "rex_files", bytes.NewReader([]byte("hello world")))
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	handler := session.db.FileHandler("rex_files")
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/"+id, nil)
+	req.Header.Set("Range", "bytes=6-")
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" || rec.Header().Get("Content-Type") != "text/plain" {
+		t.Errorf("Unexpected range response %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello.txt", nil))
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || rec.Body.String() != "hello world" || etag == "" {
+		t.Errorf("Unexpected response by name %d %q", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/hello.txt", nil)
+	req.Header.Set("If-None-Match", etag)
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotModified {
+		t.Errorf("Expected 304, got %d", rec.Code)
+	}
+}
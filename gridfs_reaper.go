@@ -0,0 +1,96 @@
+package gmgo
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// DefaultReaperInterval is the interval between runs of the file reaper when none is given
+const DefaultReaperInterval = time.Minute
+
+// ReapExpiredFiles removes the files of the bucket whose ExpiresAt is past, along with their chunks, and returns the
+// number of files removed. A TTL index can't be used as it would leave the chunks behind.
+func (s *DbSession) ReapExpiredFiles(prefix string) (int, error) {
+	files := s.gridFS(prefix).Files
+	index := mgo.Index{Key: []string{"metadata." + fileExpiresAtKey}, Sparse: true, Background: true}
+	if err := files.EnsureIndex(index); err != nil {
+		return 0, err
+	}
+
+	var expired []gridFSFile
+	err := s.retry(false, func() error {
+		query := bson.M{"metadata." + fileExpiresAtKey: bson.M{"$lte": time.Now()}}
+		return files.Find(query).Select(bson.M{"_id": 1}).All(&expired)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, f := range expired {
+		ok, err := s.removeExpiredFile(prefix, f.ID)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeExpiredFile removes the file and its chunks regardless of its references, as deduplicated files expire
+// when all their references are expired. The file is kept if its expiry was extended meanwhile.
+func (s *DbSession) removeExpiredFile(prefix string, id interface{}) (bool, error) {
+	var removed gridFSFile
+	err := s.retry(true, func() error {
+		query := bson.M{"_id": id, "metadata." + fileExpiresAtKey: bson.M{"$lte": time.Now()}}
+		_, err := s.gridFS(prefix).Files.Find(query).Apply(mgo.Change{Remove: true}, &removed)
+		return err
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.retry(true, func() error { return s.removeContent(prefix, removed) })
+}
+
+// RunFileReaper removes the expired files of the bucket every interval, using its own copy of the session. It blocks
+// until the context is done and returns the context error. Failed runs are logged and retried on the next interval.
+//
+// For example:
+//
+//	go session.RunFileReaper(ctx, "uploads", 5*time.Minute)
+func (s *DbSession) RunFileReaper(ctx context.Context, prefix string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultReaperInterval
+	}
+	session := s.Copy()
+	defer session.Close()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		n, err := session.ReapExpiredFiles(prefix)
+		if err != nil {
+			log.Printf("[GMGO] Error removing expired files in %s. Error: %s\n", prefix, err)
+			if IsNetworkError(err) {
+				session.Session.Refresh()
+			}
+		} else if n > 0 {
+			log.Printf("[GMGO] Removed %d expired files in %s\n", n, prefix)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
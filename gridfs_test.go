@@ -0,0 +1,274 @@
+package gmgo
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+func xxTestStreamGridFSFile(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	content := bytes.Repeat([]byte("gmgo"), 1024*1024)
+	id, n, err := session.SaveFromReader("stream.txt", "text/plain", "rex_files", bytes.NewReader(content))
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Save failed %d %s", n, err)
+	}
+
+	r, err := session.OpenFile(id, "rex_files")
+	if err != nil {
+		t.Fatalf("Open failed %s", err)
+	}
+	defer r.Close()
+	if r.Info().Size != int64(len(content)) || r.Info().ContentType != "text/plain" {
+		t.Errorf("Unexpected file info %+v", r.Info())
+	}
+	if _, err := r.Seek(-4, io.SeekEnd); err != nil {
+		t.Fatalf("Seek failed %s", err)
+	}
+	tail := make([]byte, 4)
+	if _, err := io.ReadFull(r, tail); err != nil || string(tail) != "gmgo" {
+		t.Errorf("Unexpected tail %q %s", tail, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if n, err := session.CopyTo(id, "rex_files", buf); err != nil || !bytes.Equal(buf.Bytes(), content) {
+		t.Errorf("Copy failed %d %s", n, err)
+	}
+}
+
+func TestGridFSFileInfo(t *testing.T) {
+	id := bson.NewObjectId()
+	info := gridFSFile{ID: id, Filename: "a.txt", Length: 3, Metadata: bson.M{"sha256": "abc", "owner": "puran"}}.info()
+	if info.ID != id.Hex() || info.Name != "a.txt" || info.Size != 3 {
+		t.Errorf("Unexpected file info %+v", info)
+	}
+	if info.SHA256 != "abc" || len(info.Metadata) != 1 || info.Metadata["owner"] != "puran" {
+		t.Errorf("SHA-256 should be moved out of the metadata, got %+v", info)
+	}
+
+	expiresAt := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	info = gridFSFile{ID: "custom", Metadata: bson.M{"sha256": "abc", "refs": 2, "expiresAt": expiresAt}}.info()
+	if info.ID != "custom" || info.Refs != 2 || !info.ExpiresAt.Equal(expiresAt) || info.Metadata != nil {
+		t.Errorf("Unexpected file info %+v", info)
+	}
+}
+
+func xxTestDedupGridFSFile(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+	session.db.Config.Buckets = map[string]BucketConfig{"rex_files": {Dedup: true}}
+
+	content := []byte("same attachment")
+	first, _, err := session.SaveFromReader("a.txt", "text/plain", "rex_files", bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	second, _, err := session.SaveFromReader("b.txt", "text/plain", "rex_files", bytes.NewReader(content))
+	if err != nil || second != first {
+		t.Fatalf("Expected the same file %s %s %v", first, second, err)
+	}
+
+	if err := session.DeleteFile(first, "rex_files"); err != nil {
+		t.Fatalf("Delete failed %s", err)
+	}
+	if _, err := session.CopyTo(first, "rex_files", new(bytes.Buffer)); err != nil {
+		t.Errorf("File should remain while referenced %s", err)
+	}
+	session.DeleteFile(first, "rex_files")
+	if _, err := session.OpenFile(first, "rex_files"); err == nil {
+		t.Errorf("File should be removed with its last reference")
+	}
+	if err := session.DeleteFile(first, "rex_files"); err != mgo.ErrNotFound {
+		t.Errorf("Expected not found for a removed file, got %v", err)
+	}
+}
+
+func xxTestListGridFSFiles(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	file := File{Name: "revisions.txt", ContentType: "text/plain", Data: []byte("v1"), Metadata: bson.M{"owner": "puran"}}
+	firstID, err := session.SaveFile(file, "rex_files")
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	file.Data = []byte("v2")
+	if _, err := session.SaveFile(file, "rex_files"); err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+
+	latest, err := session.FindFileByName("revisions.txt", "rex_files")
+	if err != nil || latest.Size != 2 || latest.Metadata["owner"] != "puran" || latest.SHA256 == "" {
+		t.Errorf("Unexpected latest revision %+v %s", latest, err)
+	}
+	revisions, _ := session.ListRevisions("revisions.txt", "rex_files")
+	if len(revisions) < 2 || revisions[len(revisions)-1].ID != firstID {
+		t.Errorf("Unexpected revisions %+v", revisions)
+	}
+
+	files, err := session.ListFiles("rex_files", Q{"metadata.owner": "puran"}, IteratorConfig{Limit: 10})
+	if err != nil || len(files) == 0 {
+		t.Errorf("List failed %s", err)
+	}
+
+	if err := session.RenameFile(firstID, "renamed.txt", "rex_files"); err != nil {
+		t.Errorf("Rename failed %s", err)
+	}
+	for _, f := range revisions {
+		if err := session.DeleteFile(f.ID, "rex_files"); err != nil {
+			t.Errorf("Delete failed %s", err)
+		}
+	}
+}
+
+func xxTestGridFSRevisions(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+	session.db.Config.Buckets = map[string]BucketConfig{"rex_files": {Revisions: 2}}
+
+	var ids []string
+	for _, v := range []string{"v1", "v2", "v3"} {
+		id, err := session.SaveFile(File{Name: "doc.txt", ContentType: "text/plain", Data: []byte(v)}, "rex_files")
+		if err != nil {
+			t.Fatalf("Save failed %s", err)
+		}
+		ids = append(ids, id)
+	}
+	revisions, _ := session.ListRevisions("doc.txt", "rex_files")
+	if len(revisions) != 2 || revisions[0].ID != ids[2] || revisions[1].ID != ids[1] {
+		t.Fatalf("Expected the 2 latest revisions, got %+v", revisions)
+	}
+
+	restored, err := session.RestoreRevision(ids[1], "rex_files")
+	if err != nil {
+		t.Fatalf("Restore failed %s", err)
+	}
+	file := new(File)
+	session.ReadFile(restored, "rex_files", file)
+	if string(file.Data) != "v2" {
+		t.Errorf("Expected restored content v2, got %s", file.Data)
+	}
+}
+
+func xxTestGridFSDedupRevisions(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+	session.db.Config.Buckets = map[string]BucketConfig{"rex_files": {Revisions: 3, Dedup: true}}
+
+	var ids []string
+	for _, v := range []string{"dedup v1", "dedup v2"} {
+		id, err := session.SaveFile(File{Name: "dedup.txt", ContentType: "text/plain", Data: []byte(v)}, "rex_files")
+		if err != nil {
+			t.Fatalf("Save failed %s", err)
+		}
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			session.DeleteFile(id, "rex_files")
+		}
+	}()
+
+	restored, err := session.RestoreRevision(ids[0], "rex_files")
+	if err != nil || restored != ids[0] {
+		t.Fatalf("Expected the restored copy to be deduplicated to %s, got %s %v", ids[0], restored, err)
+	}
+	latest, err := session.FindFileByName("dedup.txt", "rex_files")
+	if err != nil || latest.ID != ids[0] {
+		t.Errorf("Expected the restored revision to be the latest, got %+v %v", latest, err)
+	}
+
+	// saving the content of an older revision again makes it the latest too
+	if _, err := session.SaveFile(File{Name: "dedup.txt", ContentType: "text/plain", Data: []byte("dedup v2")}, "rex_files"); err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	if latest, _ := session.FindFileByName("dedup.txt", "rex_files"); latest.ID != ids[1] {
+		t.Errorf("Expected %s to be the latest, got %+v", ids[1], latest)
+	}
+}
+
+func xxTestDedupKeepsPermanentFile(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+	session.db.Config.Buckets = map[string]BucketConfig{"rex_files": {Dedup: true}}
+
+	content := []byte("permanent content")
+	id, err := session.SaveFile(File{Name: "permanent.txt", Data: content}, "rex_files")
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	defer session.DeleteFile(id, "rex_files")
+	tmp, err := session.SaveFile(File{Name: "tmp.txt", Data: content, ExpiresAt: time.Now().Add(-time.Second)}, "rex_files")
+	if err != nil || tmp != id {
+		t.Fatalf("Expected the expiring upload to be deduplicated to %s, got %s %v", id, tmp, err)
+	}
+
+	if _, err := session.ReapExpiredFiles("rex_files"); err != nil {
+		t.Fatalf("Reap failed %s", err)
+	}
+	file := new(File)
+	if err := session.ReadFile(id, "rex_files", file); err != nil || !bytes.Equal(file.Data, content) {
+		t.Errorf("Permanent file should not expire %v", err)
+	}
+}
+
+func xxTestReapExpiredFiles(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	id, err := session.SaveFile(File{Name: "tmp.txt", Data: []byte("tmp"), ExpiresAt: time.Now().Add(-time.Second)}, "rex_files")
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	if n, err := session.ReapExpiredFiles("rex_files"); err != nil || n == 0 {
+		t.Errorf("Expected expired files to be removed %d %s", n, err)
+	}
+	if _, err := session.OpenFile(id, "rex_files"); err == nil {
+		t.Errorf("Expired file should be removed")
+	}
+}
+
+func xxTestEncryptedGridFSFile(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+	session.db.Config.KeyProvider = testKeys
+	session.db.Config.Buckets = map[string]BucketConfig{"rex_files": {Encoding: FileEncoding{Compression: CompressionZstd, KeyID: "k1"}}}
+
+	content := bytes.Repeat([]byte("pii "), 200000)
+	id, err := session.SaveFile(File{Name: "pii.txt", Data: content}, "rex_files")
+	if err != nil {
+		t.Fatalf("Save failed %s", err)
+	}
+	defer session.DeleteFile(id, "rex_files")
+
+	var chunk struct {
+		Data []byte `bson:"data"`
+	}
+	if err := session.gridFS("rex_files").Chunks.Find(bson.M{"files_id": bson.ObjectIdHex(id)}).One(&chunk); err != nil {
+		t.Fatalf("Chunk not found %s", err)
+	}
+	if bytes.Contains(chunk.Data, []byte("pii")) {
+		t.Errorf("Content stored unencrypted")
+	}
+
+	file := new(File)
+	if err := session.ReadFile(id, "rex_files", file); err != nil || !bytes.Equal(file.Data, content) {
+		t.Fatalf("Read failed %s", err)
+	}
+	if file.Encoding.KeyID != "k1" || file.ByteLength != len(content) {
+		t.Errorf("Unexpected file %+v", file.Encoding)
+	}
+
+	info, _ := session.FindFileByName("pii.txt", "rex_files")
+	if sum := sha256.Sum256(content); info.SHA256 == "" || info.SHA256 == hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected a keyed hash of the content, got %s", info.SHA256)
+	}
+}
@@ -0,0 +1,327 @@
+package gmgo
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+)
+
+// DefaultOrphanGracePeriod is the age below which orphan chunks are not removed by VerifyBucket, as they may
+// belong to an upload in progress
+const DefaultOrphanGracePeriod = time.Hour
+
+// ProblemKind is the kind of integrity problem found by VerifyBucket
+type ProblemKind string
+
+const (
+	// OrphanChunks are chunks of a file that doesn't exist, e.g. left by a failed upload
+	OrphanChunks ProblemKind = "orphan chunks"
+	// MissingChunks is a file missing some of its chunks
+	MissingChunks ProblemKind = "missing chunks"
+	// MissingContent is a file whose content is not found in its FileStore
+	MissingContent ProblemKind = "missing content"
+	// SizeMismatch is a file whose chunks don't add up to its length
+	SizeMismatch ProblemKind = "size mismatch"
+	// ChecksumMismatch is a file whose content doesn't match its MD5 or SHA-256
+	ChecksumMismatch ProblemKind = "checksum mismatch"
+)
+
+// BucketProblem is an integrity problem of a GridFS bucket
+type BucketProblem struct {
+	Kind   ProblemKind
+	FileID string
+	Name   string
+	Detail string
+	// Repaired is true if the problem was repaired by removing the file or the orphan chunks
+	Repaired bool
+}
+
+func (p BucketProblem) String() string {
+	s := fmt.Sprintf("%s: file %s", p.Kind, p.FileID)
+	if p.Name != "" {
+		s += fmt.Sprintf(" (%s)", p.Name)
+	}
+	if p.Detail != "" {
+		s += ", " + p.Detail
+	}
+	if p.Repaired {
+		s += " [repaired]"
+	}
+	return s
+}
+
+// VerifyConfig configures VerifyBucket
+type VerifyConfig struct {
+	// Repair removes the orphan chunks and the corrupted files along with their chunks
+	Repair bool
+	// OrphanGracePeriod is the minimum age of the orphan chunks removed by Repair, based on the timestamp of their
+	// ObjectId file id. Defaults to DefaultOrphanGracePeriod
+	OrphanGracePeriod time.Duration
+	// Progress is called after each file is verified
+	Progress func(r BucketReport)
+}
+
+// BucketReport is the result of VerifyBucket
+type BucketReport struct {
+	Files    int
+	Chunks   int
+	Problems []BucketProblem
+}
+
+// VerifyBucket checks the integrity of all the files of the GridFS bucket with the given prefix. It reads all the
+// chunks to detect missing chunks, size mismatches and content not matching the MD5 or SHA-256 of the file, and
+// looks for orphan chunks. Encoded files are verified as stored, without decoding them, so only their MD5 is
+// checked. With Repair, corrupted files are removed along with their chunks, so they must be
+// uploaded again, and orphan chunks older than the grace period are removed.
+//
+// For example:
+//
+//	report, err := session.VerifyBucket("rex_files", gmgo.VerifyConfig{})
+//	for _, p := range report.Problems {
+//		log.Println(p)
+//	}
+func (s *DbSession) VerifyBucket(prefix string, cfg VerifyConfig) (BucketReport, error) {
+	if cfg.OrphanGracePeriod <= 0 {
+		cfg.OrphanGracePeriod = DefaultOrphanGracePeriod
+	}
+	report := BucketReport{}
+	gfs := s.gridFS(prefix)
+
+	iter := gfs.Files.Find(nil).Sort("_id").Iter()
+	var file gridFSFile
+	for iter.Next(&file) {
+		problem, chunks, err := s.verifyFile(prefix, file)
+		if err != nil {
+			iter.Close()
+			return report, err
+		}
+		report.Files++
+		report.Chunks += chunks
+		if problem != nil {
+			if cfg.Repair {
+				removed, err := s.removeFile(prefix, file)
+				if err != nil {
+					iter.Close()
+					return report, err
+				}
+				problem.Repaired = removed
+			}
+			report.Problems = append(report.Problems, *problem)
+		}
+		if cfg.Progress != nil {
+			cfg.Progress(report)
+		}
+		file = gridFSFile{}
+	}
+	if err := iter.Close(); err != nil {
+		return report, err
+	}
+
+	orphans, err := s.orphanChunks(prefix)
+	if err != nil {
+		return report, err
+	}
+	for _, o := range orphans {
+		problem := BucketProblem{Kind: OrphanChunks, FileID: fileID(o.ID), Detail: fmt.Sprintf("%d chunks", o.Chunks)}
+		report.Chunks += o.Chunks
+		if cfg.Repair && orphanExpired(o.ID, cfg.OrphanGracePeriod) {
+			if err := s.retry(true, func() error {
+				_, err := gfs.Chunks.RemoveAll(bson.M{"files_id": o.ID})
+				return err
+			}); err != nil {
+				return report, err
+			}
+			problem.Repaired = true
+		}
+		report.Problems = append(report.Problems, problem)
+	}
+	return report, nil
+}
+
+// verifyFile reads the chunks of the file in order and returns the first problem found, if any, and the number of
+// chunks read
+func (s *DbSession) verifyFile(prefix string, file gridFSFile) (*BucketProblem, int, error) {
+	if file.store() != "" {
+		problem, err := s.verifyStoredFile(prefix, file)
+		return problem, 0, err
+	}
+
+	check := newFileCheck(file)
+	iter := s.gridFS(prefix).Chunks.Find(bson.M{"files_id": file.ID}).Sort("n").Iter()
+	var chunk struct {
+		N    int    `bson:"n"`
+		Data []byte `bson:"data"`
+	}
+	for iter.Next(&chunk) {
+		check.add(chunk.N, chunk.Data)
+	}
+	if err := iter.Close(); err != nil {
+		return nil, check.read, err
+	}
+	return check.result(), check.read, nil
+}
+
+// verifyStoredFile reads the content of the file from its FileStore to check its size and SHA-256, if it's not
+// encoded
+func (s *DbSession) verifyStoredFile(prefix string, file gridFSFile) (*BucketProblem, error) {
+	store, err := fileStore(file.store())
+	if err != nil {
+		return nil, err
+	}
+	check := newFileCheck(file)
+	content, err := store.Open(storeKey(prefix, fileID(file.ID)))
+	if err == ErrContentNotFound {
+		return check.problem(MissingContent, "not found in store %s", file.store()), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	buf := make([]byte, 256*1024)
+	for {
+		n, err := content.Read(buf)
+		check.add(check.read, buf[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return check.result(), nil
+}
+
+// fileCheck verifies the chunks of a file, given in order. The content of files kept in a FileStore has no chunks
+// and only its size and checksums are verified
+type fileCheck struct {
+	file      gridFSFile
+	chunked   bool
+	expected  int
+	read      int
+	size      int64
+	md5       hash.Hash
+	sha256    hash.Hash
+	firstFail *BucketProblem
+}
+
+func newFileCheck(file gridFSFile) *fileCheck {
+	c := &fileCheck{file: file, chunked: file.store() == "", md5: md5.New(), sha256: sha256.New()}
+	if c.chunked && file.ChunkSize > 0 {
+		c.expected = int((file.Length + int64(file.ChunkSize) - 1) / int64(file.ChunkSize))
+	}
+	return c
+}
+
+func (c *fileCheck) problem(kind ProblemKind, format string, args ...interface{}) *BucketProblem {
+	return &BucketProblem{Kind: kind, FileID: fileID(c.file.ID), Name: c.file.Filename, Detail: fmt.Sprintf(format, args...)}
+}
+
+// add checks the sequence and size of the next chunk
+func (c *fileCheck) add(n int, data []byte) {
+	if c.firstFail == nil && c.chunked && n != c.read {
+		if n > c.read {
+			c.firstFail = c.problem(MissingChunks, "chunk %d of %d missing", c.read, c.expected)
+		} else {
+			c.firstFail = c.problem(SizeMismatch, "duplicate chunk %d", n)
+		}
+	}
+	if c.firstFail == nil && c.chunked && n < c.expected-1 && len(data) != c.file.ChunkSize {
+		c.firstFail = c.problem(SizeMismatch, "chunk %d has %d bytes, expected %d", n, len(data), c.file.ChunkSize)
+	}
+	c.md5.Write(data)
+	c.sha256.Write(data)
+	c.size += int64(len(data))
+	c.read++
+}
+
+// result returns the first problem found, or nil if the file is intact
+func (c *fileCheck) result() *BucketProblem {
+	if c.firstFail != nil {
+		return c.firstFail
+	}
+	switch {
+	case c.chunked && c.read < c.expected:
+		return c.problem(MissingChunks, "%d of %d chunks", c.read, c.expected)
+	case !c.chunked && c.size != c.file.Length:
+		return c.problem(SizeMismatch, "%d bytes, expected %d bytes", c.size, c.file.Length)
+	case c.chunked && (c.read > c.expected || c.size != c.file.Length):
+		return c.problem(SizeMismatch, "%d bytes in %d chunks, expected %d bytes", c.size, c.read, c.file.Length)
+	case c.file.MD5 != "" && hex.EncodeToString(c.md5.Sum(nil)) != c.file.MD5:
+		return c.problem(ChecksumMismatch, "MD5 doesn't match")
+	}
+	// the SHA-256 of encoded files is the one of the decoded content
+	if _, encoded := c.file.encoding(); encoded {
+		return nil
+	}
+	if sum, ok := c.file.Metadata[fileSHA256Key].(string); ok && hex.EncodeToString(c.sha256.Sum(nil)) != sum {
+		return c.problem(ChecksumMismatch, "SHA-256 doesn't match")
+	}
+	return nil
+}
+
+// orphanChunk is the files_id and count of chunks without a file
+type orphanChunk struct {
+	ID     interface{} `bson:"_id"`
+	Chunks int         `bson:"chunks"`
+}
+
+// orphanChunks returns the ids of the files referenced by chunks that don't exist
+func (s *DbSession) orphanChunks(prefix string) ([]orphanChunk, error) {
+	pipeline := []bson.M{
+		{"$group": bson.M{"_id": "$files_id", "chunks": bson.M{"$sum": 1}}},
+		{"$lookup": bson.M{"from": prefix + ".files", "localField": "_id", "foreignField": "_id", "as": "file"}},
+		{"$match": bson.M{"file": bson.M{"$size": 0}}},
+		{"$project": bson.M{"chunks": 1}},
+	}
+	var orphans []orphanChunk
+	err := s.retry(false, func() error {
+		return s.gridFS(prefix).Chunks.Pipe(pipeline).AllowDiskUse().All(&orphans)
+	})
+	return orphans, err
+}
+
+// orphanExpired returns true if the orphan chunks are older than the grace period. Chunks of files with ids other
+// than ObjectId can't be dated and are never removed
+func orphanExpired(id interface{}, grace time.Duration) bool {
+	oid, ok := id.(bson.ObjectId)
+	if !ok || !oid.Valid() {
+		return false
+	}
+	return time.Since(oid.Time()) > grace
+}
+
+// removeFile removes the file and its content regardless of its references. The file is only removed if it's
+// unchanged since it was verified, so a file migrated or deleted meanwhile is left as is and false is returned
+func (s *DbSession) removeFile(prefix string, file gridFSFile) (bool, error) {
+	var removed gridFSFile
+	err := s.retry(true, func() error {
+		_, err := s.gridFS(prefix).Files.Find(verifiedSelector(file)).Apply(mgo.Change{Remove: true}, &removed)
+		return err
+	})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, s.retry(true, func() error { return s.removeContent(prefix, removed) })
+}
+
+// verifiedSelector matches the file as long as its content is where and what it was when verified
+func verifiedSelector(file gridFSFile) bson.M {
+	store, _ := file.Metadata[fileStoreKey].(string)
+	return bson.M{
+		"_id":                      file.ID,
+		"length":                   file.Length,
+		"md5":                      nilIfEmpty(file.MD5),
+		"metadata." + fileStoreKey: nilIfEmpty(store),
+	}
+}
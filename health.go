@@ -0,0 +1,204 @@
+package gmgo
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// Health represents the reachability and topology of a database connection
+type Health struct {
+	DBName        string         `json:"dbName"`
+	Healthy       bool           `json:"healthy"`
+	Error         string         `json:"error,omitempty"`
+	ServerVersion string         `json:"serverVersion,omitempty"`
+	ReplicaSet    string         `json:"replicaSet,omitempty"`
+	Primary       string         `json:"primary,omitempty"`
+	Secondaries   []string       `json:"secondaries,omitempty"`
+	Members       []MemberHealth `json:"members,omitempty"`
+	CheckedAt     time.Time      `json:"checkedAt"`
+}
+
+// MemberHealth represents the state of a single replica set member
+type MemberHealth struct {
+	Host       string    `json:"host"`
+	State      string    `json:"state"`
+	Healthy    bool      `json:"healthy"`
+	OptimeDate time.Time `json:"optimeDate"`
+	// LagSeconds is how far behind the primary this member's oplog is
+	LagSeconds float64 `json:"lagSeconds"`
+}
+
+// MaxReplicationLag returns the largest replication lag among the secondaries
+func (h Health) MaxReplicationLag() time.Duration {
+	var max float64
+	for _, m := range h.Members {
+		if m.LagSeconds > max {
+			max = m.LagSeconds
+		}
+	}
+	return time.Duration(max * float64(time.Second))
+}
+
+// isMasterResult subset of the isMaster command response
+type isMasterResult struct {
+	IsMaster  bool     `bson:"ismaster"`
+	Secondary bool     `bson:"secondary"`
+	SetName   string   `bson:"setName"`
+	Primary   string   `bson:"primary"`
+	Hosts     []string `bson:"hosts"`
+	Me        string   `bson:"me"`
+}
+
+// replSetStatusResult subset of the replSetGetStatus command response
+type replSetStatusResult struct {
+	Members []replSetMember `bson:"members"`
+}
+
+type replSetMember struct {
+	Name       string    `bson:"name"`
+	Health     float64   `bson:"health"`
+	StateStr   string    `bson:"stateStr"`
+	OptimeDate time.Time `bson:"optimeDate"`
+}
+
+// Ping checks if the database is reachable. It returns the context error if the context
+// is done before the server responds.
+func (db Db) Ping(ctx context.Context) error {
+	session := db.Session()
+	done := make(chan error, 1)
+	go func() {
+		// the session is closed once the ping returns, even if the context is done before
+		defer session.Close()
+		done <- session.Session.Ping()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Health returns the topology information of the database: primary, secondaries, replication lag
+// of each member and the server version. Replica set details are empty for standalone servers.
+// It returns the context error if the context is done before the server responds.
+func (db Db) Health(ctx context.Context) (Health, error) {
+	type result struct {
+		h   Health
+		err error
+	}
+	session := db.Session()
+	done := make(chan result, 1)
+	go func() {
+		// the session is closed once the commands return, even if the context is done before
+		defer session.Close()
+		h, err := db.health(session)
+		done <- result{h, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.h, r.err
+	case <-ctx.Done():
+		h := Health{DBName: db.Config.DBName, CheckedAt: time.Now(), Error: ctx.Err().Error()}
+		return h, ctx.Err()
+	}
+}
+
+// health runs the commands reporting the topology of the database
+func (db Db) health(session *DbSession) (Health, error) {
+	h := Health{DBName: db.Config.DBName, CheckedAt: time.Now()}
+
+	info, err := session.Session.BuildInfo()
+	if err != nil {
+		h.Error = err.Error()
+		return h, err
+	}
+	h.ServerVersion = info.Version
+
+	im := isMasterResult{}
+	if err := session.Session.Run("isMaster", &im); err != nil {
+		h.Error = err.Error()
+		return h, err
+	}
+	h.ReplicaSet = im.SetName
+	h.Primary = im.Primary
+	if im.SetName == "" && im.IsMaster {
+		h.Primary = im.Me
+	}
+	for _, host := range im.Hosts {
+		if host != im.Primary {
+			h.Secondaries = append(h.Secondaries, host)
+		}
+	}
+
+	if im.SetName != "" {
+		status := replSetStatusResult{}
+		if err := session.Session.DB("admin").Run(bson.D{{Name: "replSetGetStatus", Value: 1}}, &status); err != nil {
+			h.Error = err.Error()
+			return h, err
+		}
+		h.Members = membersHealth(status)
+	}
+
+	h.Healthy = true
+	return h, nil
+}
+
+// membersHealth computes the replication lag of each member relative to the primary
+func membersHealth(status replSetStatusResult) []MemberHealth {
+	var primaryOptime time.Time
+	for _, m := range status.Members {
+		if m.StateStr == "PRIMARY" {
+			primaryOptime = m.OptimeDate
+		}
+	}
+
+	members := make([]MemberHealth, 0, len(status.Members))
+	for _, m := range status.Members {
+		mh := MemberHealth{Host: m.Name, State: m.StateStr, Healthy: m.Health == 1, OptimeDate: m.OptimeDate}
+		if !primaryOptime.IsZero() && m.OptimeDate.Before(primaryOptime) {
+			mh.LagSeconds = primaryOptime.Sub(m.OptimeDate).Seconds()
+		}
+		members = append(members, mh)
+	}
+	return members
+}
+
+// HealthHandler returns the http.Handler that reports the health of all the connections
+// registered using Setup as JSON. It responds with 200 if all the databases are healthy and
+// 503 otherwise, so it can be used for liveness and readiness endpoints.
+//
+// For example:
+//
+//	http.Handle("/health", gmgo.HealthHandler())
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		databases := make(map[string]Health, len(connectionMap))
+		for name, db := range connectionMap {
+			h := Health{DBName: name, CheckedAt: time.Now()}
+			if err := db.Ping(r.Context()); err != nil {
+				h.Error = err.Error()
+			} else {
+				h, _ = db.Health(r.Context())
+			}
+			if !h.Healthy {
+				status = http.StatusServiceUnavailable
+			}
+			databases[name] = h
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"healthy":   status == http.StatusOK,
+			"databases": databases,
+		})
+	})
+}
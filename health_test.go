@@ -0,0 +1,25 @@
+package gmgo
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMembersHealth(t *testing.T) {
+	now := time.Now()
+	status := replSetStatusResult{Members: []replSetMember{
+		{Name: "db1:27017", Health: 1, StateStr: "PRIMARY", OptimeDate: now},
+		{Name: "db2:27017", Health: 1, StateStr: "SECONDARY", OptimeDate: now.Add(-3 * time.Second)},
+	}}
+
+	h := Health{Members: membersHealth(status)}
+	if len(h.Members) != 2 {
+		t.Fatalf("Expected 2 members, got %d", len(h.Members))
+	}
+	if h.Members[0].LagSeconds != 0 {
+		t.Errorf("Primary should have no lag, got %f", h.Members[0].LagSeconds)
+	}
+	if h.MaxReplicationLag() != 3*time.Second {
+		t.Errorf("Expected 3s lag, got %s", h.MaxReplicationLag())
+	}
+}
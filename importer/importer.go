@@ -0,0 +1,360 @@
+// Package importer reads documents from JSON Lines, Extended JSON arrays, CSV or mongodump compatible BSON
+// files and writes them to a collection using batched inserts or upserts.
+//
+// For example:
+//
+//	session := db.Session()
+//	defer session.Close()
+//
+//	stats, err := importer.ImportFile("users.csv", session, "user", importer.Options{
+//		Format:       importer.CSV,
+//		UpsertFields: []string{"email"},
+//	})
+//	for _, rowErr := range stats.Errors {
+//		fmt.Printf("row %d: %s\n", rowErr.Row, rowErr.Err)
+//	}
+package importer
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/narup/gmgo"
+)
+
+// Format is the input file format
+type Format int
+
+const (
+	// JSON reads Extended JSON documents, one per line (JSON Lines) or as the elements of a top level array
+	JSON Format = iota
+	// CSV reads a header row followed by the rows. See ParseHeader for the type hints
+	CSV
+	// BSON reads the raw documents one after the other, same as the .bson files of mongodump
+	BSON
+)
+
+// DefaultBatchSize is the number of documents written per bulk operation
+const DefaultBatchSize = 1000
+
+// Options configures the import
+type Options struct {
+	Format Format
+	// UpsertFields are the fields used to match the existing document, which is replaced. Documents are inserted when empty
+	UpsertFields []string
+	// BatchSize defaults to DefaultBatchSize
+	BatchSize int
+	// DryRun reads and validates the documents without writing them
+	DryRun bool
+	// MaxErrors is the number of row errors tolerated before the import stops. Zero tolerates any number of errors
+	MaxErrors int
+	// Progress is called after each batch
+	Progress func(stats Stats)
+}
+
+// RowError is the error of a single input row. Row is the line number for CSV and the document position,
+// starting from 1, for the other formats
+type RowError struct {
+	Row int64
+	Err error
+}
+
+func (e RowError) Error() string {
+	return fmt.Sprintf("row %d: %s", e.Row, e.Err)
+}
+
+// Stats reports the result of the import
+type Stats struct {
+	// Read is the number of rows read, including the invalid ones
+	Read int64
+	// Inserted is the number of documents inserted, including the ones inserted by upserts
+	Inserted int64
+	// Replaced is the number of existing documents replaced by upserts
+	Replaced int64
+	// Failed is the number of rows that were invalid or rejected by the server
+	Failed int64
+	Errors []RowError
+}
+
+// ErrTooManyErrors is returned when the row errors exceed Options.MaxErrors
+var ErrTooManyErrors = fmt.Errorf("importer: too many errors")
+
+// documentReader reads the documents of a specific format
+type documentReader interface {
+	// next returns the next document and its row number, or io.EOF
+	next() (doc bson.D, row int64, err error)
+}
+
+// collectionName adapts a collection name to gmgo.Document
+type collectionName string
+
+func (c collectionName) CollectionName() string {
+	return string(c)
+}
+
+// ImportFile imports the documents of the file at the given path. Files ending with .gz are decompressed
+func ImportFile(path string, session *gmgo.DbSession, collection string, opts Options) (Stats, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Stats{}, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return Stats{}, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return Import(r, session, collection, opts)
+}
+
+// Import reads the documents from r and writes them to the collection. Invalid rows and rows rejected by
+// the server are reported in Stats.Errors; the returned error is for failures that stop the import.
+func Import(r io.Reader, session *gmgo.DbSession, collection string, opts Options) (Stats, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultBatchSize
+	}
+
+	var dr documentReader
+	switch opts.Format {
+	case JSON:
+		dr = newJSONReader(r)
+	case CSV:
+		var err error
+		if dr, err = newCSVReader(r); err != nil {
+			return Stats{}, err
+		}
+	case BSON:
+		dr = &bsonReader{r: bufio.NewReader(r)}
+	default:
+		return Stats{}, fmt.Errorf("importer: unknown format %d", opts.Format)
+	}
+
+	var coll *mgo.Collection
+	if !opts.DryRun {
+		coll = session.Collection(collectionName(collection))
+	}
+
+	imp := &importer{opts: opts, coll: coll}
+	for {
+		doc, row, err := dr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(RowError); !ok {
+				return imp.stats, err
+			}
+			imp.stats.Read++
+			if err := imp.fail(err.(RowError)); err != nil {
+				return imp.stats, err
+			}
+			continue
+		}
+
+		imp.stats.Read++
+		if err := imp.add(doc, row); err != nil {
+			return imp.stats, err
+		}
+	}
+	return imp.stats, imp.flush()
+}
+
+// importer accumulates the documents in batches
+type importer struct {
+	opts  Options
+	coll  *mgo.Collection
+	stats Stats
+
+	docs []bson.D
+	rows []int64
+}
+
+func (imp *importer) add(doc bson.D, row int64) error {
+	if len(imp.opts.UpsertFields) > 0 {
+		if _, err := upsertSelector(doc, imp.opts.UpsertFields); err != nil {
+			return imp.fail(RowError{Row: row, Err: err})
+		}
+	}
+
+	imp.docs = append(imp.docs, doc)
+	imp.rows = append(imp.rows, row)
+	if len(imp.docs) >= imp.opts.BatchSize {
+		return imp.flush()
+	}
+	return nil
+}
+
+// fail records the row error and returns ErrTooManyErrors when the limit is exceeded
+func (imp *importer) fail(err RowError) error {
+	imp.stats.Failed++
+	imp.stats.Errors = append(imp.stats.Errors, err)
+	if imp.opts.MaxErrors > 0 && len(imp.stats.Errors) > imp.opts.MaxErrors {
+		return ErrTooManyErrors
+	}
+	return nil
+}
+
+// flush writes the pending batch
+func (imp *importer) flush() error {
+	if len(imp.docs) == 0 {
+		return nil
+	}
+	docs, rows := imp.docs, imp.rows
+	imp.docs, imp.rows = nil, nil
+
+	var err error
+	switch {
+	case imp.opts.DryRun:
+		imp.stats.Inserted += int64(len(docs))
+	case len(imp.opts.UpsertFields) > 0:
+		err = imp.upsert(docs, rows)
+	default:
+		err = imp.insert(docs, rows)
+	}
+	if err != nil {
+		return err
+	}
+	if imp.opts.Progress != nil {
+		imp.opts.Progress(imp.stats)
+	}
+	return nil
+}
+
+// insert inserts the documents, recording the rows the server rejected
+func (imp *importer) insert(docs []bson.D, rows []int64) error {
+	bulk := imp.coll.Bulk()
+	bulk.Unordered()
+	for _, doc := range docs {
+		bulk.Insert(doc)
+	}
+
+	failed := 0
+	if _, err := bulk.Run(); err != nil {
+		berr, ok := err.(*mgo.BulkError)
+		if !ok {
+			return err
+		}
+		for _, c := range berr.Cases() {
+			if c.Index < 0 || c.Index >= len(rows) {
+				return err
+			}
+			failed++
+			if ferr := imp.fail(RowError{Row: rows[c.Index], Err: c.Err}); ferr != nil {
+				return ferr
+			}
+		}
+	}
+	imp.stats.Inserted += int64(len(docs) - failed)
+	return nil
+}
+
+// maxWriteBatchSize is the number of statements the server accepts in a single write command
+const maxWriteBatchSize = 1000
+
+// updateResult is the reply of the update command. Unlike mgo.BulkResult it tells the upserted documents
+// apart from the matched ones.
+type updateResult struct {
+	N        int `bson:"n"`
+	Upserted []struct {
+		Index int `bson:"index"`
+	} `bson:"upserted"`
+	WriteErrors []struct {
+		Index  int    `bson:"index"`
+		ErrMsg string `bson:"errmsg"`
+	} `bson:"writeErrors"`
+	WriteConcernError *struct {
+		ErrMsg string `bson:"errmsg"`
+	} `bson:"writeConcernError"`
+}
+
+// upsert replaces the documents matching the upsert fields, inserting the ones without a match
+func (imp *importer) upsert(docs []bson.D, rows []int64) error {
+	for len(docs) > 0 {
+		n := len(docs)
+		if n > maxWriteBatchSize {
+			n = maxWriteBatchSize
+		}
+
+		updates := make([]bson.D, n)
+		for i, doc := range docs[:n] {
+			selector, _ := upsertSelector(doc, imp.opts.UpsertFields)
+			updates[i] = bson.D{{Name: "q", Value: selector}, {Name: "u", Value: doc}, {Name: "upsert", Value: true}}
+		}
+		var result updateResult
+		cmd := bson.D{{Name: "update", Value: imp.coll.Name}, {Name: "updates", Value: updates}, {Name: "ordered", Value: false}}
+		if err := imp.coll.Database.Run(cmd, &result); err != nil {
+			return err
+		}
+		if err := imp.record(result, rows[:n]); err != nil {
+			return err
+		}
+		docs, rows = docs[n:], rows[n:]
+	}
+	return nil
+}
+
+// record adds the update command result to the stats. The server counts the upserted documents in n,
+// so only the rest of them replaced an existing document.
+func (imp *importer) record(result updateResult, rows []int64) error {
+	if result.WriteConcernError != nil {
+		return fmt.Errorf("write concern error: %s", result.WriteConcernError.ErrMsg)
+	}
+	for _, e := range result.WriteErrors {
+		if e.Index < 0 || e.Index >= len(rows) {
+			return fmt.Errorf("write error for unknown statement %d: %s", e.Index, e.ErrMsg)
+		}
+		if err := imp.fail(RowError{Row: rows[e.Index], Err: errors.New(e.ErrMsg)}); err != nil {
+			return err
+		}
+	}
+	imp.stats.Inserted += int64(len(result.Upserted))
+	imp.stats.Replaced += int64(result.N - len(result.Upserted))
+	return nil
+}
+
+// upsertSelector builds the selector matching the document on the upsert fields
+func upsertSelector(doc bson.D, fields []string) (bson.D, error) {
+	selector := make(bson.D, 0, len(fields))
+	for _, f := range fields {
+		v, ok := lookup(doc, f)
+		if !ok {
+			return nil, fmt.Errorf("missing upsert field %s", f)
+		}
+		selector = append(selector, bson.DocElem{Name: f, Value: v})
+	}
+	return selector, nil
+}
+
+// lookup returns the value at the dotted path
+func lookup(doc bson.D, path string) (interface{}, bool) {
+	var value interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		d, ok := value.(bson.D)
+		if !ok {
+			return nil, false
+		}
+		found := false
+		for _, e := range d {
+			if e.Name == name {
+				value, found = e.Value, true
+				break
+			}
+		}
+		if !found {
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,120 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestParseHeader(t *testing.T) {
+	columns, err := ParseHeader([]string{"name", "address.zip.string()", "created.date(2006-01-02)"})
+	if err != nil {
+		t.Fatalf("Parse failed %s", err)
+	}
+	expected := []Column{
+		{Path: "name", Type: "auto"},
+		{Path: "address.zip", Type: "string"},
+		{Path: "created", Type: "date", Arg: "2006-01-02"},
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("Unexpected columns %+v", columns)
+	}
+
+	if _, err := ParseHeader([]string{"age.uint()"}); err == nil {
+		t.Error("Expected unknown type error")
+	}
+}
+
+func TestImportCSVDryRun(t *testing.T) {
+	input := "name,age.int32(),address.zip.string(),created.date(2006-01-02)\n" +
+		"Puran,34,94105,2018-05-01\n" +
+		"Ana,old,10001,2018-05-02\n" +
+		"Bob,40,,\n"
+
+	var docs []bson.D
+	stats, err := Import(strings.NewReader(input), nil, "user", Options{Format: CSV, DryRun: true, BatchSize: 2})
+	if err != nil {
+		t.Fatalf("Import failed %s", err)
+	}
+	if stats.Read != 3 || stats.Inserted != 2 || stats.Failed != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if len(stats.Errors) != 1 || stats.Errors[0].Row != 3 || !strings.Contains(stats.Errors[0].Error(), "column age") {
+		t.Errorf("Expected error for row 3 column age, got %v", stats.Errors)
+	}
+
+	cr, _ := newCSVReader(strings.NewReader(input))
+	for {
+		doc, _, err := cr.next()
+		if err != nil {
+			break
+		}
+		docs = append(docs, doc)
+	}
+	expected := bson.D{
+		{Name: "name", Value: "Puran"},
+		{Name: "age", Value: 34},
+		{Name: "address", Value: bson.D{{Name: "zip", Value: "94105"}}},
+		{Name: "created", Value: time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	if !reflect.DeepEqual(docs[0], expected) {
+		t.Errorf("Unexpected document %#v", docs[0])
+	}
+}
+
+func TestImportUpsertFields(t *testing.T) {
+	input := `{"email": "a@x.com", "n": 1}
+{"n": 2}
+`
+	stats, err := Import(strings.NewReader(input), nil, "user", Options{Format: JSON, DryRun: true, UpsertFields: []string{"email"}})
+	if err != nil {
+		t.Fatalf("Import failed %s", err)
+	}
+	if stats.Inserted != 1 || stats.Failed != 1 || stats.Errors[0].Row != 2 {
+		t.Errorf("Expected second document to miss the upsert field, got %+v", stats)
+	}
+
+	_, err = Import(strings.NewReader(input), nil, "user", Options{Format: JSON, DryRun: true, UpsertFields: []string{"email"}, MaxErrors: 1})
+	if err != nil {
+		t.Errorf("One error should be tolerated, got %v", err)
+	}
+}
+
+func TestRecordUpsertResult(t *testing.T) {
+	var result updateResult
+	if err := bson.Unmarshal(mustMarshal(t, bson.M{
+		"n":           3,
+		"nModified":   1,
+		"upserted":    []bson.M{{"index": 0, "_id": 1}, {"index": 2, "_id": 2}},
+		"writeErrors": []bson.M{{"index": 3, "code": 11000, "errmsg": "duplicate key"}},
+	}), &result); err != nil {
+		t.Fatal(err)
+	}
+
+	imp := &importer{}
+	if err := imp.record(result, []int64{10, 11, 12, 13}); err != nil {
+		t.Fatalf("Record failed %s", err)
+	}
+	if imp.stats.Inserted != 2 || imp.stats.Replaced != 1 || imp.stats.Failed != 1 {
+		t.Errorf("Expected 2 inserted, 1 replaced and 1 failed, got %+v", imp.stats)
+	}
+	if imp.stats.Errors[0].Row != 13 || imp.stats.Errors[0].Err.Error() != "duplicate key" {
+		t.Errorf("Unexpected row error %+v", imp.stats.Errors[0])
+	}
+
+	result.WriteErrors[0].Index = 4
+	if err := imp.record(result, []int64{10, 11, 12, 13}); err == nil {
+		t.Error("Expected error for a write error outside the batch")
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := bson.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
@@ -0,0 +1,245 @@
+package importer
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+	"github.com/narup/gmgo/extjson"
+)
+
+type jsonReader struct {
+	dec *extjson.Decoder
+	row int64
+}
+
+func newJSONReader(r io.Reader) *jsonReader {
+	return &jsonReader{dec: extjson.NewDecoder(r)}
+}
+
+func (jr *jsonReader) next() (bson.D, int64, error) {
+	jr.row++
+	doc, err := jr.dec.Decode()
+	if err != nil && err != io.EOF {
+		// the JSON stream cannot be resynchronized after a syntax error, so the import stops
+		return nil, jr.row, fmt.Errorf("importer: document %d at offset %d: %s", jr.row, jr.dec.InputOffset(), err)
+	}
+	return doc, jr.row, err
+}
+
+type bsonReader struct {
+	r   *bufio.Reader
+	row int64
+}
+
+func (br *bsonReader) next() (bson.D, int64, error) {
+	size := make([]byte, 4)
+	if _, err := io.ReadFull(br.r, size); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, br.row, fmt.Errorf("importer: truncated document after document %d", br.row)
+		}
+		return nil, br.row, err
+	}
+	br.row++
+
+	n := int(binary.LittleEndian.Uint32(size))
+	if n < 5 || n > 16*1024*1024 {
+		return nil, br.row, fmt.Errorf("importer: document %d has invalid size %d", br.row, n)
+	}
+	data := make([]byte, n)
+	copy(data, size)
+	if _, err := io.ReadFull(br.r, data[4:]); err != nil {
+		return nil, br.row, fmt.Errorf("importer: truncated document %d", br.row)
+	}
+
+	doc := bson.D{}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, br.row, RowError{Row: br.row, Err: err}
+	}
+	return doc, br.row, nil
+}
+
+// Column is a CSV column parsed from the header
+type Column struct {
+	// Path is the dotted path of the field, nested documents are created for each path element
+	Path string
+	// Type is the type hint, auto when the header has none
+	Type string
+	// Arg is the argument of the type hint, e.g. the layout of date
+	Arg string
+}
+
+// ParseHeader parses the CSV header. Each column is a dotted field path with an optional type hint, e.g.
+// "address.zip.string()" or "created.date(2006-01-02)". Supported types are:
+//
+//	auto()       int, float, boolean or string, whichever parses first (default)
+//	string()
+//	int32(), int64(), double(), decimal()
+//	boolean()
+//	date(layout) Go time layout, defaults to RFC3339
+//	date_ms()    milliseconds since epoch
+//	objectId()
+//	binary()     base64 encoded
+func ParseHeader(header []string) ([]Column, error) {
+	columns := make([]Column, len(header))
+	for i, h := range header {
+		c := Column{Path: strings.TrimSpace(h), Type: "auto"}
+		if strings.HasSuffix(c.Path, ")") {
+			open := strings.LastIndex(c.Path, "(")
+			dot := strings.LastIndex(c.Path[:max(open, 0)], ".")
+			if open < 0 || dot < 0 {
+				return nil, fmt.Errorf("importer: invalid column %q", h)
+			}
+			c.Type, c.Arg = c.Path[dot+1:open], c.Path[open+1:len(c.Path)-1]
+			c.Path = c.Path[:dot]
+			if _, ok := csvTypes[c.Type]; !ok {
+				return nil, fmt.Errorf("importer: unknown type %s in column %q", c.Type, h)
+			}
+		}
+		if c.Path == "" {
+			return nil, fmt.Errorf("importer: empty column name at position %d", i+1)
+		}
+		columns[i] = c
+	}
+	return columns, nil
+}
+
+// csvTypes converts the cell value for each type hint
+var csvTypes = map[string]func(v, arg string) (interface{}, error){
+	"auto": func(v, arg string) (interface{}, error) {
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			if i >= -1<<31 && i < 1<<31 {
+				return int(i), nil
+			}
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(v, 64); err == nil {
+			return f, nil
+		}
+		if v == "true" || v == "false" {
+			return v == "true", nil
+		}
+		return v, nil
+	},
+	"string": func(v, arg string) (interface{}, error) {
+		return v, nil
+	},
+	"int32": func(v, arg string) (interface{}, error) {
+		i, err := strconv.ParseInt(v, 10, 32)
+		return int(i), err
+	},
+	"int64": func(v, arg string) (interface{}, error) {
+		return strconv.ParseInt(v, 10, 64)
+	},
+	"double": func(v, arg string) (interface{}, error) {
+		return strconv.ParseFloat(v, 64)
+	},
+	"decimal": func(v, arg string) (interface{}, error) {
+		return bson.ParseDecimal128(v)
+	},
+	"boolean": func(v, arg string) (interface{}, error) {
+		return strconv.ParseBool(v)
+	},
+	"date": func(v, arg string) (interface{}, error) {
+		if arg == "" {
+			arg = time.RFC3339Nano
+		}
+		return time.Parse(arg, v)
+	},
+	"date_ms": func(v, arg string) (interface{}, error) {
+		ms, err := strconv.ParseInt(v, 10, 64)
+		return time.Unix(0, ms*int64(time.Millisecond)).UTC(), err
+	},
+	"objectId": func(v, arg string) (interface{}, error) {
+		if !bson.IsObjectIdHex(v) {
+			return nil, fmt.Errorf("invalid ObjectId %q", v)
+		}
+		return bson.ObjectIdHex(v), nil
+	},
+	"binary": func(v, arg string) (interface{}, error) {
+		return base64.StdEncoding.DecodeString(v)
+	},
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []Column
+	row     int64
+}
+
+func newCSVReader(r io.Reader) (*csvReader, error) {
+	cr := &csvReader{r: csv.NewReader(r)}
+	cr.r.FieldsPerRecord = -1
+	cr.r.ReuseRecord = true
+
+	header, err := cr.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("importer: cannot read CSV header: %s", err)
+	}
+	if cr.columns, err = ParseHeader(header); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+func (cr *csvReader) next() (bson.D, int64, error) {
+	record, err := cr.r.Read()
+	if err == io.EOF {
+		return nil, cr.row, err
+	}
+	if err != nil {
+		if perr, ok := err.(*csv.ParseError); ok {
+			cr.row = int64(perr.StartLine)
+			return nil, cr.row, RowError{Row: cr.row, Err: perr.Err}
+		}
+		return nil, cr.row, err
+	}
+	line, _ := cr.r.FieldPos(0)
+	cr.row = int64(line)
+	if len(record) > len(cr.columns) {
+		return nil, cr.row, RowError{Row: cr.row, Err: fmt.Errorf("expected %d fields, found %d", len(cr.columns), len(record))}
+	}
+
+	doc := bson.D{}
+	for i, v := range record {
+		// empty cells are left out, like mongoimport does by default
+		if v == "" {
+			continue
+		}
+		c := cr.columns[i]
+		value, err := csvTypes[c.Type](v, c.Arg)
+		if err != nil {
+			return nil, cr.row, RowError{Row: cr.row, Err: fmt.Errorf("column %s: %s", c.Path, err)}
+		}
+		doc = setPath(doc, strings.Split(c.Path, "."), value)
+	}
+	return doc, cr.row, nil
+}
+
+// setPath sets the value at the path, creating the embedded documents as needed
+func setPath(doc bson.D, path []string, value interface{}) bson.D {
+	for i, e := range doc {
+		if e.Name != path[0] {
+			continue
+		}
+		if len(path) == 1 {
+			doc[i].Value = value
+			return doc
+		}
+		sub, _ := e.Value.(bson.D)
+		doc[i].Value = setPath(sub, path[1:], value)
+		return doc
+	}
+
+	if len(path) == 1 {
+		return append(doc, bson.DocElem{Name: path[0], Value: value})
+	}
+	return append(doc, bson.DocElem{Name: path[0], Value: setPath(bson.D{}, path[1:], value)})
+}
@@ -1,13 +1,28 @@
 package gmgo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
 	"github.com/rwynn/gtm"
 )
 
+// DefaultTailFlushInterval is the interval between checkpoints of a MongoTail when none is given
+const DefaultTailFlushInterval = 5 * time.Second
+
+// ErrTailCheckpointExpired is returned by MongoTail.Start when the checkpoint is older than the oldest oplog entry,
+// so the events since the checkpoint can't be replayed. The checkpoint must be removed, or the data resynchronized,
+// before tailing again
+var ErrTailCheckpointExpired = errors.New("tail checkpoint is older than the oplog")
+
+// errTailClosed is returned when gtm closes its channels
+var errTailClosed = errors.New("oplog tail closed")
+
 //TailEvent defines oplog tail event
 type TailEvent struct {
 	ID         interface{}
@@ -38,10 +53,159 @@ type TailEventHandler interface {
 type MongoTail struct {
 	EventHandler TailEventHandler
 	ReImport     bool
+	//MaxConsecutiveErrors stops the tail with the last error after that many errors without an event in between.
+	//Zero retries forever, as gtm does
+	MaxConsecutiveErrors int
+	//Checkpoint makes the tail resume after the last processed event on restart, see TailCheckpointConfig.
+	//Nil starts from the latest oplog entry
+	Checkpoint *TailCheckpointConfig
+}
+
+// TailCheckpointConfig makes a MongoTail resumable. The oplog timestamp of the last event processed by the
+// handler is saved as the LastID of the checkpoint, and the tail restarts after it. An event is processed once
+// its handler method returns, so events handled after the last flush are delivered again after a restart.
+// The first start saves the current oplog position, so no event is missed even before the first flush.
+//
+// For example:
+//
+//	mt := gmgo.MongoTail{EventHandler: handler, Checkpoint: &gmgo.TailCheckpointConfig{Name: "search-sync"}}
+//	err := mt.Start(ctx, session)
+type TailCheckpointConfig struct {
+	// Name identifies the checkpoint in the store
+	Name string
+	// Store persists the checkpoints, e.g. FileCheckpointStore. Defaults to CollectionCheckpointStore in the
+	// tailed session's database
+	Store CheckpointStore
+	// FlushInterval is the maximum time between checkpoints while events are processed. Defaults to
+	// DefaultTailFlushInterval
+	FlushInterval time.Duration
+	// FlushEvents is the number of events after which the checkpoint is saved without waiting for the interval.
+	// Zero only saves on FlushInterval
+	FlushEvents int
+}
+
+// tailCheckpoint tracks the oplog position of a resumable MongoTail
+type tailCheckpoint struct {
+	config  TailCheckpointConfig
+	last    Checkpoint
+	pending int
+	// resumed is true if the tail resumes from a saved checkpoint
+	resumed bool
+}
+
+// loadTailCheckpoint loads the saved position, saving the current oplog position if there's none
+func loadTailCheckpoint(dbSession *DbSession, cfg TailCheckpointConfig, options *gtm.Options) (*tailCheckpoint, error) {
+	if cfg.Store == nil {
+		cfg.Store = NewCollectionCheckpointStore(dbSession)
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultTailFlushInterval
+	}
+
+	cp := &tailCheckpoint{config: cfg, last: Checkpoint{Name: cfg.Name}}
+	saved, err := cfg.Store.LoadCheckpoint(cfg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if saved != nil {
+		if _, ok := saved.LastID.(bson.MongoTimestamp); !ok {
+			return nil, fmt.Errorf("checkpoint %s is not an oplog timestamp: %v", cfg.Name, saved.LastID)
+		}
+		cp.last = *saved
+		cp.resumed = true
+		return cp, nil
+	}
+
+	// gtm.Start sets the defaults of the options, but gtm.LastOpTimestamp needs the oplog names already
+	setOplogDefaults(options)
+	cp.last.LastID = lastOpTimestamp(dbSession.Session, options)
+	cp.pending = 1
+	return cp, cp.flush()
+}
+
+// lastOpTimestamp returns the timestamp of the latest oplog entry
+var lastOpTimestamp = gtm.LastOpTimestamp
+
+// setOplogDefaults sets the oplog database and collection names left nil to local and oplog.rs
+func setOplogDefaults(options *gtm.Options) {
+	if options.OpLogDatabaseName == nil {
+		name := "local"
+		options.OpLogDatabaseName = &name
+	}
+	if options.OpLogCollectionName == nil {
+		name := "oplog.rs"
+		options.OpLogCollectionName = &name
+	}
+}
+
+// after is the gtm.TimestampGenerator starting the tail after the checkpoint
+func (cp *tailCheckpoint) after(*mgo.Session, *gtm.Options) bson.MongoTimestamp {
+	return cp.last.LastID.(bson.MongoTimestamp)
+}
+
+// processed records the event as processed and saves the checkpoint if FlushEvents is reached
+func (cp *tailCheckpoint) processed(op *gtm.Op) error {
+	// direct reads aren't in the oplog
+	if op.Source != gtm.OplogQuerySource {
+		return nil
+	}
+	cp.last.LastID = op.Timestamp
+	cp.last.Count++
+	cp.pending++
+	if cp.config.FlushEvents > 0 && cp.pending >= cp.config.FlushEvents {
+		return cp.flush()
+	}
+	return nil
+}
+
+// flush saves the checkpoint if events were processed since the last one
+func (cp *tailCheckpoint) flush() error {
+	if cp.pending == 0 {
+		return nil
+	}
+	cp.last.UpdatedAt = time.Now()
+	if err := cp.config.Store.SaveCheckpoint(cp.last); err != nil {
+		return err
+	}
+	cp.pending = 0
+	return nil
+}
+
+// checkOplog returns ErrTailCheckpointExpired if the oplog doesn't go back to the checkpoint anymore
+func (cp *tailCheckpoint) checkOplog(dbSession *DbSession, options *gtm.Options) error {
+	if !cp.resumed {
+		return nil
+	}
+	setOplogDefaults(options)
+	dbName, collName := *options.OpLogDatabaseName, *options.OpLogCollectionName
+
+	var oldest struct {
+		Ts bson.MongoTimestamp `bson:"ts"`
+	}
+	err := dbSession.retry(false, func() error {
+		return dbSession.Session.DB(dbName).C(collName).Find(nil).Sort("$natural").Select(bson.M{"ts": 1}).One(&oldest)
+	})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if oldest.Ts > cp.after(nil, nil) {
+		return ErrTailCheckpointExpired
+	}
+	return nil
 }
 
-//Start - starts tailing mongodb oplog.
-func (mt MongoTail) Start(dbSession *DbSession) {
+//Start tails the MongoDB oplog until the context is cancelled. It blocks, and returns nil once the tail is
+//stopped, the events already fetched are handled and the checkpoint is saved. It returns an error without
+//waiting for the context if the tail can't start, the checkpoint is expired, or the tail fails irrecoverably.
+//
+//For example, with an errgroup:
+//
+//	g, ctx := errgroup.WithContext(ctx)
+//	g.Go(func() error { return mt.Start(ctx, session) })
+func (mt MongoTail) Start(ctx context.Context, dbSession *DbSession) error {
 	// nil options get initialized to gtm.DefaultOptions()
 	bd := time.Duration(750) * time.Millisecond
 	options := &gtm.Options{
@@ -59,29 +223,142 @@ func (mt MongoTail) Start(dbSession *DbSession) {
 		DirectReadNs:        []string{}, // set to a slice of namespaces to read data directly from bypassing the oplog
 		DirectReadFilter:    nil,
 	}
-	ctx := gtm.Start(dbSession.Session, options)
-	// ctx.OpC is a channel to read ops from
-	// ctx.ErrC is a channel to read errors from
-	// ctx.Stop() stops all go routines started by gtm.Start
+	var cp *tailCheckpoint
+	if mt.Checkpoint != nil {
+		var err error
+		if cp, err = loadTailCheckpoint(dbSession, *mt.Checkpoint, options); err != nil {
+			log.Printf("[GMGO] Error loading tail checkpoint %s. Error: %s\n", mt.Checkpoint.Name, err)
+			return err
+		}
+		if err = cp.checkOplog(dbSession, options); err != nil {
+			log.Printf("[GMGO] Error resuming tail checkpoint %s. Error: %s\n", mt.Checkpoint.Name, err)
+			return err
+		}
+		options.After = cp.after
+	}
+
+	opCtx := gtm.Start(dbSession.Session, options)
+	// opCtx.OpC is a channel to read ops from
+	// opCtx.ErrC is a channel to read errors from
+	// opCtx.Stop() stops all go routines started by gtm.Start
 	go func() {
-		ctx.DirectReadWg.Wait()
+		opCtx.DirectReadWg.Wait()
 		fmt.Println("[GMGO] imported all the collections")
 	}()
 
-	mt.listen(ctx)
+	return mt.listen(ctx, opCtx.OpC, opCtx.ErrC, opCtx.Stop, cp)
 }
 
-func (mt MongoTail) listen(ctx *gtm.OpCtx) {
+// listen dispatches the events until the context is done or the tail fails, then stops the tail
+func (mt MongoTail) listen(ctx context.Context, ops <-chan *gtm.Op, errs <-chan error, stop func(), cp *tailCheckpoint) error {
 	log.Printf("[GMGO] listening for MongoDB oplog events")
-	for {
-		// loop forever receiving events
+	var flush <-chan time.Time
+	if cp != nil {
+		ticker := time.NewTicker(cp.config.FlushInterval)
+		defer ticker.Stop()
+		flush = ticker.C
+	}
+
+	var terminal error
+	consecutiveErrors := 0
+	for terminal == nil {
 		select {
-		case err := <-ctx.ErrC:
+		case <-ctx.Done():
+			log.Printf("[GMGO] stopping MongoDB oplog tail")
+			return mt.stop(ops, errs, stop, cp, nil)
+		case err, ok := <-errs:
+			if !ok {
+				terminal = errTailClosed
+				break
+			}
 			mt.EventHandler.HandleError(err)
-		case op := <-ctx.OpC:
-			mt.dispatchEvents(op)
+			consecutiveErrors++
+			if mt.MaxConsecutiveErrors > 0 && consecutiveErrors >= mt.MaxConsecutiveErrors {
+				terminal = err
+			}
+		case op, ok := <-ops:
+			if !ok {
+				terminal = errTailClosed
+				break
+			}
+			consecutiveErrors = 0
+			mt.handle(op, cp)
+		case <-flush:
+			mt.checkpointError(cp.flush())
 		}
 	}
+	log.Printf("[GMGO] MongoDB oplog tail failed. Error: %s\n", terminal)
+	return mt.stop(ops, errs, stop, cp, terminal)
+}
+
+// handle dispatches the event and records it in the checkpoint
+func (mt MongoTail) handle(op *gtm.Op, cp *tailCheckpoint) {
+	mt.dispatchEvents(op)
+	if cp != nil {
+		mt.checkpointError(cp.processed(op))
+	}
+}
+
+// stop stops gtm while handling the events it still delivers, then saves the checkpoint. It returns the terminal
+// error, if any, or the error saving the checkpoint
+func (mt MongoTail) stop(ops <-chan *gtm.Op, errs <-chan error, stop func(), cp *tailCheckpoint, terminal error) error {
+	// gtm may be blocked delivering events, so they're consumed until it's stopped
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+
+	for done := false; !done; {
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				ops = nil
+				continue
+			}
+			mt.handle(op, cp)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			mt.EventHandler.HandleError(err)
+		case <-stopped:
+			done = true
+		}
+	}
+	// events buffered in the channel before the stop
+	for ops != nil {
+		select {
+		case op, ok := <-ops:
+			if !ok {
+				ops = nil
+				continue
+			}
+			mt.handle(op, cp)
+		default:
+			ops = nil
+		}
+	}
+
+	if cp != nil {
+		if err := cp.flush(); err != nil {
+			log.Printf("[GMGO] Error saving tail checkpoint %s. Error: %s\n", cp.config.Name, err)
+			if terminal == nil {
+				terminal = err
+			}
+		}
+	}
+	return terminal
+}
+
+// checkpointError reports a failure to save the checkpoint, which is retried on the next flush
+func (mt MongoTail) checkpointError(err error) {
+	if err == nil {
+		return
+	}
+	log.Printf("[GMGO] Error saving tail checkpoint %s. Error: %s\n", mt.Checkpoint.Name, err)
+	mt.EventHandler.HandleError(err)
 }
 
 func (mt MongoTail) dispatchEvents(op *gtm.Op) {
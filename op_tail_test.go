@@ -1,8 +1,15 @@
 package gmgo
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/globalsign/mgo/bson"
+	"github.com/rwynn/gtm"
 )
 
 type mongoEventHandler struct {
@@ -32,5 +39,151 @@ func xxTestTailing(t *testing.T) {
 	mt := new(MongoTail)
 	mt.EventHandler = mongoEventHandler{}
 
-	mt.Start(session)
+	mt.Start(context.Background(), session)
+}
+
+func TestTailCheckpoint(t *testing.T) {
+	store := memoryCheckpointStore{"sync": Checkpoint{Name: "sync", LastID: bson.MongoTimestamp(10), Count: 5}}
+	cp, err := loadTailCheckpoint(nil, TailCheckpointConfig{Name: "sync", Store: store, FlushEvents: 2}, &gtm.Options{})
+	if err != nil {
+		t.Fatalf("Load failed %s", err)
+	}
+	if ts := cp.after(nil, nil); ts != 10 {
+		t.Errorf("Expected to resume after 10, got %d", ts)
+	}
+	if cp.config.FlushInterval != DefaultTailFlushInterval {
+		t.Errorf("Expected default flush interval, got %s", cp.config.FlushInterval)
+	}
+
+	cp.processed(&gtm.Op{Timestamp: 11, Source: gtm.OplogQuerySource})
+	cp.processed(&gtm.Op{Source: gtm.DirectQuerySource})
+	if store["sync"].LastID != bson.MongoTimestamp(10) {
+		t.Errorf("Checkpoint saved before FlushEvents %+v", store["sync"])
+	}
+	cp.processed(&gtm.Op{Timestamp: 12, Source: gtm.OplogQuerySource})
+	if c := store["sync"]; c.LastID != bson.MongoTimestamp(12) || c.Count != 7 || cp.pending != 0 {
+		t.Errorf("Unexpected checkpoint %+v", c)
+	}
+
+	cp.processed(&gtm.Op{Timestamp: 13, Source: gtm.OplogQuerySource})
+	if err := cp.flush(); err != nil || store["sync"].LastID != bson.MongoTimestamp(13) {
+		t.Errorf("Flush failed %+v %v", store["sync"], err)
+	}
+
+	store["other"] = Checkpoint{Name: "other", LastID: bson.NewObjectId()}
+	if _, err := loadTailCheckpoint(nil, TailCheckpointConfig{Name: "other", Store: store}, &gtm.Options{}); err == nil {
+		t.Error("Expected error for a checkpoint that isn't an oplog timestamp")
+	}
+}
+
+func TestTailCheckpointOplogDefaults(t *testing.T) {
+	defer func(f func(*mgo.Session, *gtm.Options) bson.MongoTimestamp) { lastOpTimestamp = f }(lastOpTimestamp)
+	lastOpTimestamp = func(_ *mgo.Session, o *gtm.Options) bson.MongoTimestamp {
+		// gtm dereferences the oplog names
+		if *o.OpLogDatabaseName != "local" || *o.OpLogCollectionName != "oplog.rs" {
+			t.Errorf("Unexpected oplog %s.%s", *o.OpLogDatabaseName, *o.OpLogCollectionName)
+		}
+		return 42
+	}
+
+	store := memoryCheckpointStore{}
+	cp, err := loadTailCheckpoint(&DbSession{}, TailCheckpointConfig{Name: "new", Store: store}, &gtm.Options{})
+	if err != nil {
+		t.Fatalf("Load failed %s", err)
+	}
+	if cp.after(nil, nil) != 42 || store["new"].LastID != bson.MongoTimestamp(42) {
+		t.Errorf("Expected to start after the latest oplog entry, got %+v", store["new"])
+	}
+
+	name := "oplog.$main"
+	options := &gtm.Options{OpLogCollectionName: &name}
+	setOplogDefaults(options)
+	if *options.OpLogDatabaseName != "local" || *options.OpLogCollectionName != "oplog.$main" {
+		t.Errorf("Expected the oplog collection to be kept, got %s", *options.OpLogCollectionName)
+	}
+}
+
+func xxTestResumableTailing(t *testing.T) {
+	session := testDBSession()
+
+	mt := MongoTail{EventHandler: mongoEventHandler{}}
+	mt.Checkpoint = &TailCheckpointConfig{Name: "test-tail", FlushEvents: 100}
+
+	mt.Start(context.Background(), session)
+}
+
+type countingEventHandler struct {
+	events []interface{}
+	errors int
+}
+
+func (ch *countingEventHandler) HandleInsertEvent(event TailEvent) {
+	ch.events = append(ch.events, event.ID)
+}
+func (ch *countingEventHandler) HandleUpdateEvent(event TailEvent) {
+	ch.events = append(ch.events, event.ID)
+}
+func (ch *countingEventHandler) HandleDeleteEvent(event TailEvent) {
+	ch.events = append(ch.events, event.ID)
+}
+func (ch *countingEventHandler) HandleDropEvent(event TailEvent) {
+	ch.events = append(ch.events, event.ID)
+}
+func (ch *countingEventHandler) HandleError(err error) { ch.errors++ }
+
+func TestTailGracefulStop(t *testing.T) {
+	handler := &countingEventHandler{}
+	store := memoryCheckpointStore{}
+	cp := &tailCheckpoint{config: TailCheckpointConfig{Name: "sync", Store: store, FlushInterval: time.Hour}, last: Checkpoint{Name: "sync"}}
+	mt := MongoTail{EventHandler: handler, Checkpoint: &cp.config}
+
+	ops := make(chan *gtm.Op, 10)
+	errs := make(chan error)
+	ctx, cancel := context.WithCancel(context.Background())
+	ops <- &gtm.Op{Id: 1, Operation: "i", Timestamp: 1}
+	ops <- &gtm.Op{Id: 2, Operation: "u", Timestamp: 2}
+
+	stopped := false
+	stop := func() {
+		// events still delivered while gtm stops
+		ops <- &gtm.Op{Id: 3, Operation: "d", Timestamp: 3}
+		stopped = true
+	}
+	done := make(chan error)
+	go func() { done <- mt.listen(ctx, ops, errs, stop, cp) }()
+	cancel()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Expected clean stop, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Tail didn't stop")
+	}
+	if !stopped || len(handler.events) != 3 {
+		t.Errorf("Expected gtm stopped and 3 events handled, got %v %v", stopped, handler.events)
+	}
+	if c := store["sync"]; c.LastID != bson.MongoTimestamp(3) || c.Count != 3 {
+		t.Errorf("Expected checkpoint flushed on stop, got %+v", c)
+	}
+}
+
+func TestTailTerminalError(t *testing.T) {
+	handler := &countingEventHandler{}
+	mt := MongoTail{EventHandler: handler, MaxConsecutiveErrors: 2}
+
+	ops := make(chan *gtm.Op)
+	errs := make(chan error, 3)
+	errs <- errors.New("first")
+	errs <- errors.New("second")
+	err := mt.listen(context.Background(), ops, errs, func() {}, nil)
+	if err == nil || err.Error() != "second" || handler.errors != 2 {
+		t.Errorf("Expected the second error to stop the tail, got %v after %d errors", err, handler.errors)
+	}
+
+	close(ops)
+	if err := mt.listen(context.Background(), ops, make(chan error), func() {}, nil); err != errTailClosed {
+		t.Errorf("Expected closed tail error, got %v", err)
+	}
 }
@@ -0,0 +1,295 @@
+package gmgo
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// ErrInvalidPageToken is returned when the page token is malformed, was modified or was created
+// for a different collection, query or sort order
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// paginationKey signs the page tokens. It's random per process unless set using SetPaginationKey.
+var paginationKey = func() []byte {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}()
+
+// SetPaginationKey sets the secret used to sign page tokens. It should be set to the same value on all
+// the instances of a service so that the tokens returned by one instance are accepted by the others.
+func SetPaginationKey(key []byte) {
+	paginationKey = key
+}
+
+// Page is a page of documents returned by Paginate
+type Page struct {
+	// Documents is the slice of documents, of the same type as the FindAll result
+	Documents interface{}
+	// Next is the token of the next page, empty if this is the last page
+	Next string
+	// Prev is the token of the previous page, empty if this is the first page
+	Prev string
+}
+
+// pageToken is the payload of the opaque page token
+type pageToken struct {
+	Values   []interface{} `bson:"v"`
+	Backward bool          `bson:"b"`
+}
+
+// sortKey is a single field of the sort order
+type sortKey struct {
+	field string
+	desc  bool
+}
+
+// Paginate returns a page of documents using keyset pagination: instead of skipping documents, the
+// page token encodes the sort key values of the last (or first) document of the current page and the
+// next page is queried from there. _id is appended to the sort order to break ties. Pass an empty token
+// to get the first page. Sort fields may be null or missing, but must otherwise hold values of a single
+// BSON type, as MongoDB only compares values of the same type.
+//
+// For example:
+//
+//	page, err := session.Paginate(gmgo.Q{"state": "CA"}, []string{"-createdDate"}, 50, r.URL.Query().Get("page"), new(user))
+//	if err != nil {
+//		return err
+//	}
+//	users := page.Documents.([]*user)
+//	// return users with page.Next and page.Prev links
+func (s *DbSession) Paginate(query Q, sortBy []string, pageSize int, token string, document Document) (*Page, error) {
+	if pageSize <= 0 {
+		return nil, errors.New("page size must be greater than zero")
+	}
+
+	keys := sortKeys(sortBy)
+	scope, err := pageScope(document.CollectionName(), query)
+	if err != nil {
+		return nil, err
+	}
+	pt := pageToken{}
+	if token != "" {
+		if pt, err = decodePageToken(token, keys, scope); err != nil {
+			return nil, err
+		}
+	}
+
+	q := Q{}
+	for k, v := range query {
+		q[k] = v
+	}
+	if pt.Values != nil {
+		q = Q{"$and": []interface{}{q, keysetFilter(keys, pt.Values, pt.Backward)}}
+	}
+
+	var raws []bson.Raw
+	err = s.retry(false, func() error {
+		return s.findQueryByCollectionName(document.CollectionName(), q).
+			Sort(sortFields(keys, pt.Backward)...).
+			Limit(pageSize + 1).
+			All(&raws)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	hasMore := len(raws) > pageSize
+	if hasMore {
+		raws = raws[:pageSize]
+	}
+	if pt.Backward {
+		for i, j := 0, len(raws)-1; i < j; i, j = i+1, j-1 {
+			raws[i], raws[j] = raws[j], raws[i]
+		}
+	}
+
+	documents := slice(document)
+	list := reflect.ValueOf(documents).Elem()
+	elemType := list.Type().Elem()
+	for _, raw := range raws {
+		var elem reflect.Value
+		if elemType.Kind() == reflect.Ptr {
+			elem = reflect.New(elemType.Elem())
+		} else {
+			elem = reflect.New(elemType)
+		}
+		if err := raw.Unmarshal(elem.Interface()); err != nil {
+			return nil, err
+		}
+		if elemType.Kind() != reflect.Ptr {
+			elem = elem.Elem()
+		}
+		list = reflect.Append(list, elem)
+	}
+	reflect.ValueOf(documents).Elem().Set(list)
+
+	page := &Page{}
+	page.Documents, _ = results(documents)
+	if len(raws) == 0 {
+		return page, nil
+	}
+
+	// there's a next page if the query found more documents going forward, or if this page
+	// was fetched going backward from a later document
+	if hasMore || pt.Backward {
+		if page.Next, err = encodePageToken(raws[len(raws)-1], keys, scope, false); err != nil {
+			return nil, err
+		}
+	}
+	if (pt.Backward && hasMore) || (!pt.Backward && pt.Values != nil) {
+		if page.Prev, err = encodePageToken(raws[0], keys, scope, true); err != nil {
+			return nil, err
+		}
+	}
+	return page, nil
+}
+
+// sortKeys parses the sort fields and appends _id to break ties
+func sortKeys(sortBy []string) []sortKey {
+	keys := make([]sortKey, 0, len(sortBy)+1)
+	hasID := false
+	for _, f := range sortBy {
+		k := sortKey{field: strings.TrimPrefix(f, "+")}
+		if strings.HasPrefix(f, "-") {
+			k.field, k.desc = f[1:], true
+		}
+		if k.field == "" {
+			continue
+		}
+		hasID = hasID || k.field == "_id"
+		keys = append(keys, k)
+	}
+	if !hasID {
+		keys = append(keys, sortKey{field: "_id"})
+	}
+	return keys
+}
+
+// sortFields returns the mgo sort fields, reversed when paging backward
+func sortFields(keys []sortKey, backward bool) []string {
+	fields := make([]string, len(keys))
+	for i, k := range keys {
+		fields[i] = k.field
+		if k.desc != backward {
+			fields[i] = "-" + k.field
+		}
+	}
+	return fields
+}
+
+// keysetFilter returns the query matching the documents after (or before when backward) the given
+// sort key values: (k1 > v1) or (k1 = v1 and k2 > v2) or ... Null and missing values sort before any
+// other value but never match $gt or $lt, so they're compared explicitly.
+func keysetFilter(keys []sortKey, values []interface{}, backward bool) Q {
+	or := make([]interface{}, 0, len(keys)+1)
+	for i, k := range keys {
+		clause := func(value interface{}) bson.M {
+			c := bson.M{}
+			for j := 0; j < i; j++ {
+				c[keys[j].field] = values[j]
+			}
+			c[k.field] = value
+			return c
+		}
+
+		descending := k.desc != backward
+		switch {
+		case values[i] == nil && descending:
+			// nothing sorts before null
+		case values[i] == nil:
+			or = append(or, clause(bson.M{"$ne": nil}))
+		case descending:
+			or = append(or, clause(bson.M{"$lt": values[i]}), clause(nil))
+		default:
+			or = append(or, clause(bson.M{"$gt": values[i]}))
+		}
+	}
+	return Q{"$or": or}
+}
+
+// sortSignature identifies the sort order the token was created for
+func sortSignature(keys []sortKey) string {
+	return strings.Join(sortFields(keys, false), ",")
+}
+
+// pageScope identifies the collection and query the tokens are created for, so they can't be used to page
+// through another collection or with another filter. encoding/json sorts the map keys, so equal queries
+// have the same scope
+func pageScope(collection string, query Q) ([]byte, error) {
+	q, err := json.Marshal(query)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(collection+"\x00"), q...), nil
+}
+
+func encodePageToken(raw bson.Raw, keys []sortKey, scope []byte, backward bool) (string, error) {
+	doc := bson.M{}
+	if err := raw.Unmarshal(&doc); err != nil {
+		return "", err
+	}
+
+	pt := pageToken{Backward: backward, Values: make([]interface{}, len(keys))}
+	for i, k := range keys {
+		pt.Values[i] = lookupField(doc, k.field)
+	}
+
+	payload, err := bson.Marshal(pt)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(signPageToken(payload, keys, scope)), nil
+}
+
+func decodePageToken(token string, keys []sortKey, scope []byte) (pageToken, error) {
+	pt := pageToken{}
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return pt, ErrInvalidPageToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return pt, ErrInvalidPageToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, signPageToken(payload, keys, scope)) {
+		return pt, ErrInvalidPageToken
+	}
+	if err := bson.Unmarshal(payload, &pt); err != nil || len(pt.Values) != len(keys) {
+		return pt, ErrInvalidPageToken
+	}
+	return pt, nil
+}
+
+func signPageToken(payload []byte, keys []sortKey, scope []byte) []byte {
+	mac := hmac.New(sha256.New, paginationKey)
+	mac.Write(scope)
+	mac.Write([]byte{0})
+	mac.Write([]byte(sortSignature(keys)))
+	mac.Write([]byte{0})
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// lookupField returns the value of the field, following dotted paths into embedded documents
+func lookupField(doc bson.M, path string) interface{} {
+	var value interface{} = doc
+	for _, name := range strings.Split(path, ".") {
+		m, ok := value.(bson.M)
+		if !ok {
+			return nil
+		}
+		value = m[name]
+	}
+	return value
+}
@@ -0,0 +1,99 @@
+package gmgo
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestPageToken(t *testing.T) {
+	keys := sortKeys([]string{"-createdDate", "fullName"})
+	if fields := sortFields(keys, false); !reflect.DeepEqual(fields, []string{"-createdDate", "fullName", "_id"}) {
+		t.Errorf("Unexpected sort fields %v", fields)
+	}
+	if fields := sortFields(keys, true); !reflect.DeepEqual(fields, []string{"createdDate", "-fullName", "-_id"}) {
+		t.Errorf("Unexpected backward sort fields %v", fields)
+	}
+
+	id := bson.NewObjectId()
+	created := time.Date(2018, 5, 1, 0, 0, 0, 0, time.UTC)
+	raw := bson.Raw{}
+	data, _ := bson.Marshal(bson.M{"_id": id, "createdDate": created, "fullName": "Puran"})
+	bson.Unmarshal(data, &raw)
+
+	scope, _ := pageScope("user", Q{"state": "CA", "age": bson.M{"$gt": 21}})
+	token, err := encodePageToken(raw, keys, scope, false)
+	if err != nil {
+		t.Fatalf("Encode failed %s", err)
+	}
+	pt, err := decodePageToken(token, keys, scope)
+	if err != nil {
+		t.Fatalf("Decode failed %s", err)
+	}
+	if pt.Values[1] != "Puran" || pt.Values[2] != id || !pt.Values[0].(time.Time).Equal(created) {
+		t.Errorf("Unexpected token values %v", pt.Values)
+	}
+
+	if _, err := decodePageToken(token, sortKeys([]string{"fullName"}), scope); err != ErrInvalidPageToken {
+		t.Errorf("Token should be rejected for a different sort, got %v", err)
+	}
+	same, _ := pageScope("user", Q{"age": bson.M{"$gt": 21}, "state": "CA"})
+	if _, err := decodePageToken(token, keys, same); err != nil {
+		t.Errorf("Token should be accepted for the same query, got %v", err)
+	}
+	for _, scope := range [][]byte{mustPageScope("account", Q{"state": "CA", "age": bson.M{"$gt": 21}}), mustPageScope("user", Q{"state": "NY"})} {
+		if _, err := decodePageToken(token, keys, scope); err != ErrInvalidPageToken {
+			t.Errorf("Token should be rejected for %s, got %v", scope, err)
+		}
+	}
+	tampered := []byte(token)
+	tampered[3] ^= 1
+	if _, err := decodePageToken(string(tampered), keys, scope); err != ErrInvalidPageToken {
+		t.Errorf("Tampered token should be rejected, got %v", err)
+	}
+}
+
+func TestKeysetFilter(t *testing.T) {
+	keys := sortKeys([]string{"-score"})
+	filter := keysetFilter(keys, []interface{}{10, "x"}, false)
+	expected := Q{"$or": []interface{}{
+		bson.M{"score": bson.M{"$lt": 10}},
+		bson.M{"score": nil},
+		bson.M{"score": 10, "_id": bson.M{"$gt": "x"}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected filter %v", filter)
+	}
+}
+
+func TestKeysetFilterNull(t *testing.T) {
+	keys := sortKeys([]string{"nickname"})
+	filter := keysetFilter(keys, []interface{}{nil, "x"}, false)
+	expected := Q{"$or": []interface{}{
+		bson.M{"nickname": bson.M{"$ne": nil}},
+		bson.M{"nickname": nil, "_id": bson.M{"$gt": "x"}},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected filter after null %v", filter)
+	}
+
+	// going backward from null only the ties on _id remain
+	filter = keysetFilter(keys, []interface{}{nil, "x"}, true)
+	expected = Q{"$or": []interface{}{
+		bson.M{"nickname": nil, "_id": bson.M{"$lt": "x"}},
+		bson.M{"nickname": nil, "_id": nil},
+	}}
+	if !reflect.DeepEqual(filter, expected) {
+		t.Errorf("Unexpected filter before null %v", filter)
+	}
+}
+
+func mustPageScope(collection string, query Q) []byte {
+	scope, err := pageScope(collection, query)
+	if err != nil {
+		panic(err)
+	}
+	return scope
+}
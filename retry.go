@@ -0,0 +1,164 @@
+package gmgo
+
+import (
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+// RetryPolicy defines how DbSession operations are retried when they fail with a transient error,
+// for example when the primary steps down and the sockets of the session are closed.
+// Reads are always retried, writes only when RetryWrites is set since retrying a write that
+// reached the server could apply it twice (e.g. Save of a document without _id).
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one. Values less than 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait before the first retry. Defaults to 100ms
+	InitialBackoff time.Duration
+	// MaxBackoff caps the exponentially growing wait between retries. Defaults to 5s
+	MaxBackoff time.Duration
+	// Multiplier grows the backoff after each attempt. Defaults to 2
+	Multiplier float64
+	// Jitter is the fraction (0 to 1) of the backoff that is randomized to avoid retry storms
+	Jitter float64
+	// RetryWrites enables retries for Save, Update, UpdateFieldValue, Remove and RemoveAll
+	RetryWrites bool
+	// Retryable decides whether the error is transient. Defaults to IsTransientError
+	Retryable func(err error) bool
+}
+
+// DefaultRetryPolicy is a reasonable retry policy for reads
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 100 * time.Millisecond,
+	MaxBackoff:     5 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+// server error codes returned while a replica set elects a new primary or a node shuts down
+var transientErrorCodes = map[int]bool{
+	6:     true, // HostUnreachable
+	7:     true, // HostNotFound
+	89:    true, // NetworkTimeout
+	91:    true, // ShutdownInProgress
+	189:   true, // PrimarySteppedDown
+	10107: true, // NotMaster
+	11600: true, // InterruptedAtShutdown
+	11602: true, // InterruptedDueToReplStateChange
+	13435: true, // NotMasterNoSlaveOk
+	13436: true, // NotMasterOrSecondary
+}
+
+// IsNetworkError returns true if the error is caused by a broken or closed connection to the server.
+// The session must be refreshed before it can be used again after such error.
+func IsNetworkError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	return msg == "Closed explicitly" ||
+		strings.Contains(msg, "no reachable servers") ||
+		strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe") ||
+		strings.Contains(msg, "i/o timeout")
+}
+
+// IsTransientError returns true for network errors and server errors caused by a replica set
+// state change, which are likely to succeed when retried
+func IsTransientError(err error) bool {
+	if err == nil || err == mgo.ErrNotFound {
+		return false
+	}
+	if IsNetworkError(err) {
+		return true
+	}
+	switch e := err.(type) {
+	case *mgo.QueryError:
+		return transientErrorCodes[e.Code]
+	case *mgo.LastError:
+		return transientErrorCodes[e.Code]
+	}
+	return strings.Contains(err.Error(), "not master")
+}
+
+// withDefaults returns a copy of the policy with zero valued fields set to their defaults
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = DefaultRetryPolicy.InitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = DefaultRetryPolicy.MaxBackoff
+	}
+	if p.Multiplier < 1 {
+		p.Multiplier = DefaultRetryPolicy.Multiplier
+	}
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	} else if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	if p.Retryable == nil {
+		p.Retryable = IsTransientError
+	}
+	return p
+}
+
+// backoff returns the wait before the given retry attempt (starting from 1). r is a random
+// number in [0, 1) used to apply the jitter.
+func (p RetryPolicy) backoff(attempt int, r float64) time.Duration {
+	d := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(attempt-1))
+	if d > float64(p.MaxBackoff) {
+		d = float64(p.MaxBackoff)
+	}
+	d -= d * p.Jitter * r
+	return time.Duration(d)
+}
+
+// Retry runs the given function applying the retry policy from DbConfig.Retry. fn should be
+// idempotent since it may be called more than once. The session is refreshed after a network error
+// so the next attempt gets a new socket.
+//
+// For example:
+//
+//	err := session.Retry(func() error {
+//		return session.Pipe(pipeline, new(user)).All(&result)
+//	})
+func (s *DbSession) Retry(fn func() error) error {
+	return s.retry(false, fn)
+}
+
+// retry runs fn with retries if there's a retry policy configured and the operation allows it
+func (s *DbSession) retry(write bool, fn func() error) error {
+	if s.db.Config.Retry == nil || (write && !s.db.Config.Retry.RetryWrites) {
+		return fn()
+	}
+
+	policy := s.db.Config.Retry.withDefaults()
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || attempt >= policy.MaxAttempts || !policy.Retryable(err) {
+			return err
+		}
+
+		if IsNetworkError(err) {
+			s.Session.Refresh()
+		}
+		wait := policy.backoff(attempt, rand.Float64())
+		log.Printf("[GMGO] attempt %d failed with '%s', retrying in %s\n", attempt, err, wait)
+		time.Sleep(wait)
+	}
+}
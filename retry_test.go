@@ -0,0 +1,68 @@
+package gmgo
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	p := RetryPolicy{InitialBackoff: 100 * time.Millisecond, MaxBackoff: time.Second, Multiplier: 2, Jitter: 0.5}.withDefaults()
+
+	if d := p.backoff(1, 0); d != 100*time.Millisecond {
+		t.Errorf("Expected 100ms, got %s", d)
+	}
+	if d := p.backoff(3, 0); d != 400*time.Millisecond {
+		t.Errorf("Expected 400ms, got %s", d)
+	}
+	if d := p.backoff(10, 0); d != time.Second {
+		t.Errorf("Expected backoff capped at 1s, got %s", d)
+	}
+	if d := p.backoff(1, 1); d != 50*time.Millisecond {
+		t.Errorf("Expected jitter to halve the backoff, got %s", d)
+	}
+}
+
+func TestIsTransientError(t *testing.T) {
+	if !IsTransientError(io.EOF) {
+		t.Error("EOF should be transient")
+	}
+	if !IsTransientError(&mgo.QueryError{Code: 10107, Message: "not master"}) {
+		t.Error("NotMaster should be transient")
+	}
+	if IsTransientError(mgo.ErrNotFound) {
+		t.Error("ErrNotFound should not be transient")
+	}
+	if IsTransientError(errors.New("invalid id")) {
+		t.Error("Regular error should not be transient")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	s := &DbSession{db: Db{Config: DbConfig{Retry: &policy}}}
+
+	calls := 0
+	err := s.retry(false, func() error {
+		calls++
+		if calls < 3 {
+			return &mgo.QueryError{Code: 189, Message: "primary stepped down"}
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("Expected success after 3 calls, got %d calls and error %v", calls, err)
+	}
+
+	calls = 0
+	s.retry(true, func() error {
+		calls++
+		return &mgo.QueryError{Code: 189}
+	})
+	if calls != 1 {
+		t.Errorf("Writes should not be retried unless RetryWrites is set, got %d calls", calls)
+	}
+}
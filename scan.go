@@ -0,0 +1,546 @@
+package gmgo
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// SplitMethod defines how ParallelScan splits the collection into _id ranges
+type SplitMethod int
+
+const (
+	// SplitBySampling picks the range boundaries from a random sample of _id values using $sample
+	SplitBySampling SplitMethod = iota
+	// SplitByVector uses the splitVector command, which needs the clusterManager or splitVector privilege
+	SplitByVector
+)
+
+// ParallelScanConfig configures ParallelScan
+type ParallelScanConfig struct {
+	// Workers is the number of goroutines scanning partitions concurrently. Defaults to 4
+	Workers int
+	// Partitions is the number of _id ranges the collection is split into. Defaults to Workers * 4. The ranges are
+	// split on the most common type of _id values, and one more partition scans the _id values of the other types
+	Partitions int
+	// Split is the method used to find the range boundaries
+	Split SplitMethod
+	// SampleSize is the number of documents sampled with SplitBySampling. Defaults to Partitions * 100
+	SampleSize int
+	// PageSize is the cursor batch size of each worker
+	PageSize int
+	// MaxErrors is the number of handler errors tolerated before the scan is cancelled. Zero stops on the first error
+	MaxErrors int
+	// Progress is called every ProgressInterval and once when the scan ends
+	Progress func(p ScanProgress)
+	// ProgressInterval defaults to 5s
+	ProgressInterval time.Duration
+}
+
+// ScanProgress is the aggregated progress of all the ParallelScan workers
+type ScanProgress struct {
+	Partitions          int
+	CompletedPartitions int
+	Documents           int64
+	Errors              int
+	Elapsed             time.Duration
+}
+
+// ScanError is an error returned by the handler or the cursor of a partition
+type ScanError struct {
+	Partition int
+	ID        interface{}
+	Err       error
+}
+
+func (e ScanError) Error() string {
+	if e.ID != nil {
+		return fmt.Sprintf("partition %d, document %v: %s", e.Partition, e.ID, e.Err)
+	}
+	return fmt.Sprintf("partition %d: %s", e.Partition, e.Err)
+}
+
+// ScanErrors is the list of errors collected during ParallelScan
+type ScanErrors []ScanError
+
+func (e ScanErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d scan errors: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// idRange is a partition of the collection: lower bound inclusive, upper bound exclusive, nil for unbounded.
+// Range queries only match _id values of the type of their bounds, so the _id values of other types are scanned
+// by the partition with notType set to the type of the bounds
+type idRange struct {
+	min, max interface{}
+	notType  string
+}
+
+// scanState aggregates the progress of the workers
+type scanState struct {
+	partitions int
+	completed  int64
+	documents  int64
+	started    time.Time
+
+	mutex  sync.Mutex
+	errors ScanErrors
+}
+
+func (st *scanState) progress() ScanProgress {
+	st.mutex.Lock()
+	errCount := len(st.errors)
+	st.mutex.Unlock()
+	return ScanProgress{
+		Partitions:          st.partitions,
+		CompletedPartitions: int(atomic.LoadInt64(&st.completed)),
+		Documents:           atomic.LoadInt64(&st.documents),
+		Errors:              errCount,
+		Elapsed:             time.Since(st.started),
+	}
+}
+
+// addError records the error and returns the number of errors so far
+func (st *scanState) addError(err ScanError) int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+	st.errors = append(st.errors, err)
+	return len(st.errors)
+}
+
+// ParallelScan scans the documents matching the query by splitting the collection into _id ranges that are
+// read concurrently by the workers, each with its own copy of the session. Each document is decoded to a new
+// value of the same type as the given document and passed to the handler, which must be safe for concurrent use.
+// The scan stops when the context is cancelled or the handler errors exceed MaxErrors. It returns the final
+// progress and ScanErrors if any error was collected.
+//
+// For example:
+//
+//	progress, err := session.ParallelScan(ctx, gmgo.Q{}, new(user), gmgo.ParallelScanConfig{Workers: 8},
+//		func(d interface{}) error {
+//			return backfill(d.(*user))
+//		})
+func (s *DbSession) ParallelScan(ctx context.Context, query Q, document Document, cfg ParallelScanConfig,
+	handler func(d interface{}) error) (ScanProgress, error) {
+	if cfg.Workers <= 0 {
+		cfg.Workers = 4
+	}
+	if cfg.Partitions <= 0 {
+		cfg.Partitions = cfg.Workers * 4
+	}
+	if cfg.ProgressInterval <= 0 {
+		cfg.ProgressInterval = 5 * time.Second
+	}
+
+	ranges, err := s.splitRanges(document.CollectionName(), cfg)
+	if err != nil {
+		return ScanProgress{}, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	st := &scanState{partitions: len(ranges), started: time.Now()}
+	partitions := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < cfg.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session := s.Copy()
+			defer session.Close()
+
+			for p := range partitions {
+				session.scanPartition(ctx, p, ranges[p], query, document, cfg, handler, st, cancel)
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	tickerDone := make(chan struct{})
+	if cfg.Progress != nil {
+		go func() {
+			defer close(tickerDone)
+			ticker := time.NewTicker(cfg.ProgressInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					cfg.Progress(st.progress())
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+feed:
+	for p := range ranges {
+		select {
+		case partitions <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(partitions)
+	wg.Wait()
+	close(done)
+
+	progress := st.progress()
+	if cfg.Progress != nil {
+		// the final call must not overlap the last tick
+		<-tickerDone
+		cfg.Progress(progress)
+	}
+	if len(st.errors) > 0 {
+		return progress, st.errors
+	}
+	return progress, ctx.Err()
+}
+
+// scanPartition reads all the documents of the _id range and passes them to the handler
+func (s *DbSession) scanPartition(ctx context.Context, p int, r idRange, query Q, document Document, cfg ParallelScanConfig,
+	handler func(d interface{}) error, st *scanState, cancel context.CancelFunc) {
+	idCond := bson.M{}
+	if r.min != nil {
+		idCond["$gte"] = r.min
+	}
+	if r.max != nil {
+		idCond["$lt"] = r.max
+	}
+	if r.notType != "" {
+		idCond["$not"] = bson.M{"$type": r.notType}
+	}
+	q := query
+	if len(idCond) > 0 {
+		if q == nil {
+			q = Q{}
+		}
+		q = Q{"$and": []interface{}{q, bson.M{"_id": idCond}}}
+	}
+
+	mq := s.findQueryByCollectionName(document.CollectionName(), q)
+	if cfg.PageSize > 0 {
+		mq = mq.Batch(cfg.PageSize)
+	}
+	iter := mq.Iter()
+	defer iter.Close()
+
+	docType := reflect.TypeOf(document)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		var d reflect.Value
+		if docType.Kind() == reflect.Ptr {
+			d = reflect.New(docType.Elem())
+		} else {
+			d = reflect.New(docType)
+		}
+		if !iter.Next(d.Interface()) {
+			break
+		}
+		atomic.AddInt64(&st.documents, 1)
+
+		if docType.Kind() != reflect.Ptr {
+			d = d.Elem()
+		}
+		if err := handler(d.Interface()); err != nil {
+			if st.addError(ScanError{Partition: p, ID: documentID(d), Err: err}) > cfg.MaxErrors {
+				cancel()
+				return
+			}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		if st.addError(ScanError{Partition: p, Err: err}) > cfg.MaxErrors {
+			cancel()
+		}
+		return
+	}
+	atomic.AddInt64(&st.completed, 1)
+}
+
+// splitRanges splits the collection into _id ranges using the configured split method
+func (s *DbSession) splitRanges(collection string, cfg ParallelScanConfig) ([]idRange, error) {
+	if cfg.Split == SplitByVector {
+		bounds, err := s.splitVectorBounds(collection, cfg.Partitions)
+		if err != nil {
+			return nil, err
+		}
+		idType, bounds, err := orderedIDs(bounds)
+		if err != nil {
+			return nil, err
+		}
+		// all the distinct split keys are kept
+		return rangesFromBounds(quantiles(bounds, len(bounds)+1), idType), nil
+	}
+
+	ids, err := s.sampleIDs(collection, cfg)
+	if err != nil {
+		return nil, err
+	}
+	idType, ids, err := orderedIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	return rangesFromBounds(quantiles(ids, cfg.Partitions), idType), nil
+}
+
+// sampleIDs returns a random sample of _id values
+func (s *DbSession) sampleIDs(collection string, cfg ParallelScanConfig) ([]interface{}, error) {
+	size := cfg.SampleSize
+	if size <= 0 {
+		size = cfg.Partitions * 100
+	}
+
+	var sample []struct {
+		ID interface{} `bson:"_id"`
+	}
+	pipeline := []bson.M{{"$sample": bson.M{"size": size}}, {"$project": bson.M{"_id": 1}}}
+	err := s.retry(false, func() error {
+		return s.collection(collection).Pipe(pipeline).All(&sample)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]interface{}, len(sample))
+	for i, doc := range sample {
+		ids[i] = doc.ID
+	}
+	return ids, nil
+}
+
+// quantiles picks partitions - 1 distinct boundaries at the quantiles of the sorted _id values
+func quantiles(ids []interface{}, partitions int) []interface{} {
+	bounds := make([]interface{}, 0, partitions)
+	for i := 1; i < partitions && len(ids) > 0; i++ {
+		b := ids[i*len(ids)/partitions]
+		if len(bounds) == 0 || compareIDs(bounds[len(bounds)-1], b) < 0 {
+			bounds = append(bounds, b)
+		}
+	}
+	return bounds
+}
+
+// splitVectorBounds uses the splitVector command with a chunk size that yields roughly the given partitions
+func (s *DbSession) splitVectorBounds(collection string, partitions int) ([]interface{}, error) {
+	stats := struct {
+		Size int64 `bson:"size"`
+	}{}
+	db := s.Session.DB(s.db.Config.DBName)
+	if err := db.Run(bson.D{{Name: "collStats", Value: collection}}, &stats); err != nil {
+		return nil, err
+	}
+	chunkMB := stats.Size / int64(partitions) / (1024 * 1024)
+	if chunkMB < 1 {
+		chunkMB = 1
+	}
+
+	result := struct {
+		SplitKeys []bson.M `bson:"splitKeys"`
+	}{}
+	cmd := bson.D{
+		{Name: "splitVector", Value: s.db.Config.DBName + "." + collection},
+		{Name: "keyPattern", Value: bson.M{"_id": 1}},
+		{Name: "maxChunkSize", Value: chunkMB},
+	}
+	if err := db.Run(cmd, &result); err != nil {
+		return nil, err
+	}
+
+	bounds := make([]interface{}, len(result.SplitKeys))
+	for i, key := range result.SplitKeys {
+		bounds[i] = key["_id"]
+	}
+	return bounds, nil
+}
+
+// rangesFromBounds turns the sorted boundaries of the given $type into contiguous ranges covering all _id values
+// of that type, plus the partition of the _id values of the other types. Without bounds, a single range covers
+// the whole collection
+func rangesFromBounds(bounds []interface{}, idType string) []idRange {
+	if len(bounds) == 0 {
+		return []idRange{{}}
+	}
+	ranges := make([]idRange, 0, len(bounds)+2)
+	var min interface{}
+	for _, b := range bounds {
+		ranges = append(ranges, idRange{min: min, max: b})
+		min = b
+	}
+	return append(ranges, idRange{min: min}, idRange{notType: idType})
+}
+
+// idTypes are the $type aliases of the _id values ParallelScan can split, in BSON comparison order
+var idTypes = []string{"number", "string", "objectId", "date"}
+
+// idType returns the $type alias of the _id value, empty if it can't be ordered by compareIDs
+func idType(v interface{}) string {
+	switch v.(type) {
+	case int, int64, float64:
+		return "number"
+	case string:
+		return "string"
+	case bson.ObjectId:
+		return "objectId"
+	case time.Time:
+		return "date"
+	}
+	return ""
+}
+
+// orderedIDs returns the $type alias of the most common type of the _id values and the values of that type in
+// BSON order. The other values are left to the partition of the other types. It fails if none can be ordered
+func orderedIDs(ids []interface{}) (string, []interface{}, error) {
+	counts := make(map[string]int)
+	for _, id := range ids {
+		counts[idType(id)]++
+	}
+	best := ""
+	for _, t := range idTypes {
+		if counts[t] > counts[best] || best == "" && counts[t] > 0 {
+			best = t
+		}
+	}
+	if best == "" {
+		if len(ids) > 0 {
+			return "", nil, fmt.Errorf("can't split the _id values of type %T into ranges", ids[0])
+		}
+		return "", nil, nil
+	}
+
+	ordered := make([]interface{}, 0, counts[best])
+	for _, id := range ids {
+		if idType(id) == best {
+			ordered = append(ordered, id)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool { return compareIDs(ordered[i], ordered[j]) < 0 })
+	return best, ordered, nil
+}
+
+// compareIDs orders the _id values like MongoDB: by type in BSON comparison order, numbers numerically whatever
+// their type, ObjectIds by their bytes. The values must be of one of the idTypes
+func compareIDs(a, b interface{}) int {
+	if ta, tb := typeOrder(idType(a)), typeOrder(idType(b)); ta != tb {
+		return ta - tb
+	}
+	switch av := a.(type) {
+	case bson.ObjectId:
+		return strings.Compare(string(av), string(b.(bson.ObjectId)))
+	case string:
+		return strings.Compare(av, b.(string))
+	case time.Time:
+		bv := b.(time.Time)
+		if av.Before(bv) {
+			return -1
+		} else if av.After(bv) {
+			return 1
+		}
+		return 0
+	}
+	return compareNumbers(a, b)
+}
+
+func typeOrder(t string) int {
+	for i, it := range idTypes {
+		if it == t {
+			return i
+		}
+	}
+	return len(idTypes)
+}
+
+// compareNumbers compares int, int64 and float64 values numerically, integers exactly
+func compareNumbers(a, b interface{}) int {
+	ai, aInt := toInt64(a)
+	bi, bInt := toInt64(b)
+	if aInt && bInt {
+		switch {
+		case ai < bi:
+			return -1
+		case ai > bi:
+			return 1
+		}
+		return 0
+	}
+	af, bf := toFloat64(a), toFloat64(b)
+	// NaN is lower than any other number
+	if math.IsNaN(af) || math.IsNaN(bf) {
+		return btoi(math.IsNaN(bf)) - btoi(math.IsNaN(af))
+	}
+	switch {
+	case af < bf:
+		return -1
+	case af > bf:
+		return 1
+	}
+	return 0
+}
+
+func btoi(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int64:
+		return n, true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) float64 {
+	if n, ok := toInt64(v); ok {
+		return float64(n)
+	}
+	f, _ := v.(float64)
+	return f
+}
+
+// documentID returns the value of the _id field of the decoded document, if it can be found
+func documentID(d reflect.Value) interface{} {
+	for d.Kind() == reflect.Ptr || d.Kind() == reflect.Interface {
+		if d.IsNil() {
+			return nil
+		}
+		d = d.Elem()
+	}
+	switch d.Kind() {
+	case reflect.Map:
+		if v := d.MapIndex(reflect.ValueOf("_id")); v.IsValid() {
+			return v.Interface()
+		}
+	case reflect.Struct:
+		t := d.Type()
+		for i := 0; i < t.NumField(); i++ {
+			tag := strings.Split(t.Field(i).Tag.Get("bson"), ",")[0]
+			if tag == "_id" && t.Field(i).PkgPath == "" {
+				return d.Field(i).Interface()
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package gmgo
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestRangesFromBounds(t *testing.T) {
+	ranges := rangesFromBounds([]interface{}{10, 20}, "number")
+	expected := []idRange{{max: 10}, {min: 10, max: 20}, {min: 20}, {notType: "number"}}
+	if !reflect.DeepEqual(ranges, expected) {
+		t.Errorf("Unexpected ranges %+v", ranges)
+	}
+
+	if ranges := rangesFromBounds(nil, ""); len(ranges) != 1 || ranges[0] != (idRange{}) {
+		t.Errorf("Expected a single unbounded range, got %+v", ranges)
+	}
+}
+
+func TestCompareIDs(t *testing.T) {
+	oid := bson.ObjectIdHex("5713f1b0e4b067fc28d6fbaa")
+	date := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	// in BSON order
+	ordered := []interface{}{math.NaN(), -1.5, 2, int64(99), 100, 100.5, "", "a", oid, date}
+	for i := range ordered {
+		for j := range ordered {
+			got := compareIDs(ordered[i], ordered[j])
+			if (i < j && got >= 0) || (i > j && got <= 0) || (i == j && got != 0) {
+				t.Errorf("compareIDs(%v, %v) = %d", ordered[i], ordered[j], got)
+			}
+		}
+	}
+	if compareIDs(5, 5.0) != 0 || compareIDs(int64(5), 5) != 0 {
+		t.Error("Expected numbers of different types to be equal")
+	}
+}
+
+func TestOrderedIDs(t *testing.T) {
+	ids := []interface{}{100.0, "x", 99, int64(7), 1e3, bson.NewObjectId(), 42.5}
+	idType, ordered, err := orderedIDs(ids)
+	if err != nil || idType != "number" {
+		t.Fatalf("Expected numbers, got %s %v", idType, err)
+	}
+	expected := []interface{}{int64(7), 42.5, 99, 100.0, 1e3}
+	if !reflect.DeepEqual(ordered, expected) {
+		t.Errorf("Expected %v, got %v", expected, ordered)
+	}
+
+	bounds := quantiles(ordered, 3)
+	ranges := rangesFromBounds(bounds, idType)
+	for i := 1; i < len(bounds); i++ {
+		if compareIDs(bounds[i-1], bounds[i]) >= 0 {
+			t.Errorf("Bounds not ordered %v", bounds)
+		}
+	}
+	if last := ranges[len(ranges)-1]; last.notType != "number" {
+		t.Errorf("Expected the partition of the other types, got %+v", last)
+	}
+
+	if _, _, err := orderedIDs([]interface{}{bson.M{"a": 1}}); err == nil {
+		t.Error("Expected error for _id values that can't be ordered")
+	}
+	if idType, ids, err := orderedIDs(nil); idType != "" || ids != nil || err != nil {
+		t.Errorf("Expected no bounds for an empty sample, got %s %v %v", idType, ids, err)
+	}
+}
+
+func TestDocumentID(t *testing.T) {
+	id := bson.NewObjectId()
+	if got := documentID(reflect.ValueOf(&user{ID: id})); got != id {
+		t.Errorf("Expected %s, got %v", id.Hex(), got)
+	}
+	if got := documentID(reflect.ValueOf(bson.M{"_id": 7})); got != 7 {
+		t.Errorf("Expected 7, got %v", got)
+	}
+	if compareIDs(bson.ObjectIdHex("5713f1b0e4b067fc28d6fbaa"), bson.ObjectIdHex("5713f1b0e4b067fc28d6fbab")) >= 0 {
+		t.Error("Expected object ids to be ordered")
+	}
+}
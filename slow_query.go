@@ -0,0 +1,161 @@
+package gmgo
+
+import (
+	"encoding/json"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+// DefaultSlowQueryThreshold is the duration above which a query is reported when SlowQueryConfig.Threshold is zero.
+// Same as the default slowms of the MongoDB profiler
+const DefaultSlowQueryThreshold = 100 * time.Millisecond
+
+// SlowQueryConfig enables the slow query monitor of the database connection. Find, FindByID, FindAll, Exists,
+// PipeAll and DocumentIterator calls taking longer than the threshold are reported with their query shape.
+// Pipelines run through the mgo.Pipe returned by Pipe aren't observed.
+//
+// For example:
+//
+//	gmgo.Setup(gmgo.DbConfig{HostURL: url, DBName: "users", SlowQuery: &gmgo.SlowQueryConfig{
+//		Threshold: 200 * time.Millisecond,
+//		Explain:   true,
+//	}})
+type SlowQueryConfig struct {
+	// Threshold is the duration above which a query is reported. Defaults to DefaultSlowQueryThreshold
+	Threshold time.Duration
+	// Report is called for each slow query. Defaults to logging the query
+	Report func(q SlowQuery)
+	// Explain runs Explain on slow find queries to report the plan and whether the query scanned the collection.
+	// It doubles the cost of the slow queries, so it's meant for troubleshooting
+	Explain bool
+}
+
+// SlowQuery describes a query that took longer than the slow query threshold
+type SlowQuery struct {
+	Collection string
+	// Operation is the gmgo call, e.g. Find, FindAll, PipeAll or DocumentIterator
+	Operation string
+	// Shape is the query or pipeline with the values replaced by "?", e.g. {"age":{"$gt":"?"},"state":"?"}
+	Shape    string
+	Duration time.Duration
+	// CollScan is true if the query plan scans the whole collection. Only set when Explain is enabled
+	CollScan bool
+	// Plan is the query plan. Only set when Explain is enabled and the operation is a find
+	Plan *ExplainPlan
+}
+
+// logSlowQuery is the default reporter of slow queries
+func logSlowQuery(q SlowQuery) {
+	log.Printf("[GMGO] slow %s on %s took %s: %s\n", q.Operation, q.Collection, q.Duration, q.Shape)
+	if q.CollScan {
+		log.Printf("[GMGO] WARNING %s on %s scans the whole collection (COLLSCAN), examined %d documents to return %d\n",
+			q.Operation, q.Collection, q.Plan.DocsExamined, q.Plan.DocsReturned)
+	}
+}
+
+// observeQuery reports the query if it took longer than the slow query threshold. The find query is explained
+// when the config asks for it, cfg holds its query options and is nil for aggregations.
+func (s *DbSession) observeQuery(op, collection string, query interface{}, cfg *IteratorConfig, elapsed time.Duration) {
+	sq := s.db.Config.SlowQuery
+	if sq == nil {
+		return
+	}
+	threshold := sq.Threshold
+	if threshold <= 0 {
+		threshold = DefaultSlowQueryThreshold
+	}
+	if elapsed < threshold {
+		return
+	}
+
+	slow := SlowQuery{Collection: collection, Operation: op, Shape: queryShape(query), Duration: elapsed}
+	if sq.Explain && cfg != nil {
+		q, _ := query.(Q)
+		plan, err := s.explain(collection, q, *cfg)
+		if err != nil {
+			log.Printf("[GMGO] explain of slow %s on %s failed: %s\n", op, collection, err)
+		} else {
+			slow.Plan = plan
+			slow.CollScan = plan.CollScan
+		}
+	}
+
+	report := sq.Report
+	if report == nil {
+		report = logSlowQuery
+	}
+	report(slow)
+}
+
+// queryShape returns the JSON of the query with all the values replaced by "?", so queries differing only by
+// their values have the same shape
+func queryShape(query interface{}) string {
+	b, err := json.Marshal(shapeOf(reflect.ValueOf(query)))
+	if err != nil {
+		return "?"
+	}
+	return string(b)
+}
+
+func shapeOf(v reflect.Value) interface{} {
+	if !v.IsValid() {
+		return nil
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch d := v.Interface().(type) {
+	case bson.D:
+		shape := make(map[string]interface{}, len(d))
+		for _, e := range d {
+			shape[e.Name] = shapeOf(reflect.ValueOf(e.Value))
+		}
+		return shape
+	case bson.ObjectId, time.Time, bson.Binary, bson.RegEx, []byte:
+		return "?"
+	}
+
+	switch v.Kind() {
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return "?"
+		}
+		shape := make(map[string]interface{}, v.Len())
+		for _, k := range v.MapKeys() {
+			shape[k.String()] = shapeOf(v.MapIndex(k))
+		}
+		return shape
+	case reflect.Slice, reflect.Array:
+		// lists of documents, e.g. $or or pipeline stages, keep their shape, lists of values like $in don't
+		shape := make([]interface{}, 0, v.Len())
+		for i := 0; i < v.Len(); i++ {
+			e := shapeOf(v.Index(i))
+			if e == "?" {
+				return "?"
+			}
+			shape = append(shape, e)
+		}
+		return shape
+	}
+	return "?"
+}
+
+// observeIterator accumulates the time spent reading the cursor, which is reported once the iteration ends.
+// Tailable cursors are not observed as they wait for new documents by design
+func (pd *DocumentIterator) observeIterator(started time.Time, done bool) {
+	if pd.session == nil || pd.session.db.Config.SlowQuery == nil || pd.observed || pd.config.Tailable {
+		return
+	}
+	pd.elapsed += time.Since(started)
+	if done {
+		pd.observed = true
+		pd.session.observeQuery("DocumentIterator", pd.collection, pd.filter, &pd.config, pd.elapsed)
+	}
+}
@@ -0,0 +1,56 @@
+package gmgo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func TestQueryShape(t *testing.T) {
+	tests := []struct {
+		query interface{}
+		shape string
+	}{
+		{nil, "null"},
+		{Q{"state": "CA", "age": bson.M{"$gt": 21}}, `{"age":{"$gt":"?"},"state":"?"}`},
+		{Q{"_id": bson.NewObjectId()}, `{"_id":"?"}`},
+		{Q{"state": bson.M{"$in": []string{"CA", "NY"}}}, `{"state":{"$in":"?"}}`},
+		{Q{"$or": []interface{}{bson.M{"a": 1}, bson.M{"b": 2}}}, `{"$or":[{"a":"?"},{"b":"?"}]}`},
+		{[]bson.M{{"$match": bson.M{"state": "CA"}}, {"$limit": 10}}, `[{"$match":{"state":"?"}},{"$limit":"?"}]`},
+		{bson.D{{Name: "createdDate", Value: time.Now()}}, `{"createdDate":"?"}`},
+	}
+	for _, test := range tests {
+		if shape := queryShape(test.query); shape != test.shape {
+			t.Errorf("Expected shape %s, got %s", test.shape, shape)
+		}
+	}
+}
+
+func TestObserveQuery(t *testing.T) {
+	var reported []SlowQuery
+	s := &DbSession{db: Db{Config: DbConfig{SlowQuery: &SlowQueryConfig{
+		Threshold: 50 * time.Millisecond,
+		Report:    func(q SlowQuery) { reported = append(reported, q) },
+	}}}}
+
+	s.observeQuery("Find", "user", Q{"state": "CA"}, &IteratorConfig{}, 10*time.Millisecond)
+	if len(reported) != 0 {
+		t.Fatalf("Fast query should not be reported")
+	}
+	s.observeQuery("Find", "user", Q{"state": "CA"}, &IteratorConfig{}, 80*time.Millisecond)
+	if len(reported) != 1 {
+		t.Fatalf("Slow query should be reported")
+	}
+	if q := reported[0]; q.Operation != "Find" || q.Collection != "user" || q.Shape != `{"state":"?"}` || q.Plan != nil {
+		t.Errorf("Unexpected slow query %+v", q)
+	}
+
+	pd := &DocumentIterator{session: s, collection: "user", filter: Q{}}
+	pd.observeIterator(time.Now().Add(-30*time.Millisecond), false)
+	pd.observeIterator(time.Now().Add(-30*time.Millisecond), true)
+	pd.observeIterator(time.Now().Add(-30*time.Millisecond), true)
+	if len(reported) != 2 || reported[1].Operation != "DocumentIterator" || reported[1].Duration < 60*time.Millisecond {
+		t.Errorf("Iterator should be reported once with the accumulated time, got %+v", reported)
+	}
+}
@@ -0,0 +1,80 @@
+package gmgo
+
+import (
+	"context"
+	"iter"
+)
+
+// StreamResult is a document, or the error that ended the stream, delivered by Stream
+type StreamResult[T any] struct {
+	Document T
+	Err      error
+}
+
+// Documents returns a range-over-func iterator over the documents of the DocumentIterator, decoding each
+// to a new value of type T. The iteration ends with a non-nil error if the cursor fails, and the cursor is
+// closed when the loop ends, breaks or returns.
+//
+// For example:
+//
+//	itr := session.DocumentIterator(gmgo.Q{"state": "CA"}, "user")
+//	itr.Load(gmgo.IteratorConfig{PageSize: 500})
+//	for usr, err := range gmgo.Documents[*user](itr) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(usr.FullName)
+//	}
+func Documents[T any](pd *DocumentIterator) iter.Seq2[T, error] {
+	return func(yield func(T, error) bool) {
+		defer pd.Close()
+		for {
+			var d T
+			if !pd.FetchNext(&d) {
+				break
+			}
+			if !yield(d, nil) {
+				return
+			}
+		}
+
+		if err := pd.Error(); err != nil {
+			var zero T
+			yield(zero, err)
+		}
+	}
+}
+
+// Stream delivers the documents of the DocumentIterator on a channel buffered up to the given size, so the
+// cursor is read ahead while the consumer processes the documents. The channel is closed when all the
+// documents are delivered, after a result carrying the cursor error, or when the context is done. The cursor
+// is closed in all the cases. The consumer must drain the channel or cancel the context.
+//
+// For example:
+//
+//	ctx, cancel := context.WithCancel(ctx)
+//	defer cancel()
+//	for r := range gmgo.Stream[*user](ctx, itr, 100) {
+//		if r.Err != nil {
+//			return r.Err
+//		}
+//		process(r.Document)
+//	}
+func Stream[T any](ctx context.Context, pd *DocumentIterator, buffer int) <-chan StreamResult[T] {
+	if buffer < 0 {
+		buffer = 0
+	}
+	results := make(chan StreamResult[T], buffer)
+
+	go func() {
+		defer close(results)
+		for d, err := range Documents[T](pd) {
+			select {
+			case results <- StreamResult[T]{Document: d, Err: err}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return results
+}
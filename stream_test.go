@@ -0,0 +1,40 @@
+package gmgo
+
+import (
+	"context"
+	"testing"
+)
+
+func xxTestDocumentsRange(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	itr := session.DocumentIterator(Q{"state": "CA"}, "rexUser")
+	itr.Load(IteratorConfig{PageSize: 100, SortBy: []string{"-_id"}})
+	for usr, err := range Documents[*user](itr) {
+		if err != nil {
+			t.Errorf("Iteration failed %s", err)
+			return
+		}
+		println(usr.ID.Hex())
+	}
+}
+
+func xxTestStream(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	itr := session.DocumentIterator(Q{"state": "CA"}, "rexUser")
+	count := 0
+	for r := range Stream[*user](ctx, itr, 50) {
+		if r.Err != nil {
+			t.Errorf("Stream failed %s", r.Err)
+			return
+		}
+		count++
+	}
+	println(count)
+}
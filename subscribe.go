@@ -0,0 +1,113 @@
+package gmgo
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+const (
+	// subscribeAwaitTimeout is how long the tailable cursor waits for new documents before the context is checked again
+	subscribeAwaitTimeout = time.Second
+	// subscribeRetryDelay is the wait before the tailable cursor is re-established after it dies
+	subscribeRetryDelay = time.Second
+)
+
+// Subscribe opens a tailable cursor on the capped collection and calls the handler for each document matching
+// the query, including the documents inserted after the call. When the cursor dies, e.g. the collection was empty or
+// the connection was lost, it's re-established resuming after the _id of the last document handled, so the
+// collection is expected to use increasing ids like ObjectId. Subscribe blocks until the context is done, in which
+// case it returns the context error, or the handler returns an error, which is returned as is.
+//
+// For example:
+//
+//	err := session.Subscribe(ctx, "events", gmgo.Q{"type": "signup"}, func(doc bson.Raw) error {
+//		evt := new(event)
+//		if err := doc.Unmarshal(evt); err != nil {
+//			return err
+//		}
+//		return handleEvent(evt)
+//	})
+func (s *DbSession) Subscribe(ctx context.Context, collection string, query Q, handler func(doc bson.Raw) error) error {
+	session := s.Copy()
+	defer session.Close()
+
+	var lastID interface{}
+	for {
+		q := query
+		if lastID != nil {
+			if q == nil {
+				q = Q{}
+			}
+			q = Q{"$and": []interface{}{q, bson.M{"_id": bson.M{"$gt": lastID}}}}
+		}
+
+		itr := session.DocumentIterator(q, collection)
+		itr.Load(IteratorConfig{Tailable: true, AwaitTimeout: subscribeAwaitTimeout})
+		id, err := tailDocuments(ctx, itr, handler)
+		if id != nil {
+			lastID = id
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if he, ok := err.(handlerError); ok {
+			return he.err
+		}
+		if err != nil {
+			log.Printf("[GMGO] tailable cursor on %s failed: %s. Retrying\n", collection, err)
+			if IsNetworkError(err) {
+				session.Session.Refresh()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(subscribeRetryDelay):
+		}
+	}
+}
+
+// handlerError wraps the error returned by the subscription handler to tell it apart from cursor errors
+type handlerError struct {
+	err error
+}
+
+func (e handlerError) Error() string {
+	return e.err.Error()
+}
+
+// tailDocuments reads the tailable cursor until it dies or the context is done, and returns the _id of the last
+// document handled
+func tailDocuments(ctx context.Context, itr *DocumentIterator, handler func(doc bson.Raw) error) (interface{}, error) {
+	defer itr.Close()
+
+	var lastID interface{}
+	for {
+		raw := bson.Raw{}
+		if itr.FetchNext(&raw) {
+			id := struct {
+				ID interface{} `bson:"_id"`
+			}{}
+			if err := raw.Unmarshal(&id); err != nil {
+				return lastID, err
+			}
+			if err := handler(raw); err != nil {
+				return lastID, handlerError{err}
+			}
+			lastID = id.ID
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return lastID, nil
+		}
+		if itr.IsTimeout() {
+			continue
+		}
+		return lastID, itr.Error()
+	}
+}
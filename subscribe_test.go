@@ -0,0 +1,30 @@
+package gmgo
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/globalsign/mgo/bson"
+)
+
+func xxTestSubscribe(t *testing.T) {
+	session := testDBSession()
+	defer session.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := session.Subscribe(ctx, "rexEvents", Q{}, func(doc bson.Raw) error {
+		evt := bson.M{}
+		if err := doc.Unmarshal(&evt); err != nil {
+			return err
+		}
+		fmt.Printf("%+v\n", evt)
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected subscription to end with the context, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package gmgo
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sync"
+
+	"github.com/globalsign/mgo"
+)
+
+var (
+	// ErrNoTenant is returned when the tenant cannot be resolved from the context
+	ErrNoTenant = errors.New("tenant not found in context")
+	// ErrTenantNotAllowed is returned for tenants that are not in the allowlist
+	ErrTenantNotAllowed = errors.New("tenant not allowed")
+	// ErrInvalidTenant is returned for tenant ids that cannot be used in a database name
+	ErrInvalidTenant = errors.New("invalid tenant id")
+	// ErrInvalidDatabaseName is returned when the tenant database name is not a valid MongoDB database name
+	ErrInvalidDatabaseName = errors.New("invalid tenant database name")
+)
+
+// tenant ids end up in the database name, so they are restricted to a safe character set
+var tenantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,48}$`)
+
+// databaseNamePattern excludes the characters MongoDB doesn't allow in database names, and limits the length
+var databaseNamePattern = regexp.MustCompile(`^[^/\\. "$*<>:|?\x00]{1,63}$`)
+
+type tenantContextKey struct{}
+
+// WithTenant returns a copy of the context carrying the tenant id, used by the default tenant resolver
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantContextKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant id set using WithTenant
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenantID, ok := ctx.Value(tenantContextKey{}).(string)
+	return tenantID, ok && tenantID != ""
+}
+
+// TenantConfig configures the TenantRouter
+type TenantConfig struct {
+	// DatabasePrefix is prepended to the tenant id to build the tenant database name
+	DatabasePrefix string
+	// DatabaseName builds the database name for the tenant id. Defaults to DatabasePrefix + tenant id
+	DatabaseName func(tenantID string) string
+	// Resolver returns the tenant id for the context. Defaults to TenantFromContext
+	Resolver func(ctx context.Context) (string, error)
+	// Allowed is the list of tenants that can get a session. When empty, AllowTenant must be used
+	// to register tenants, or AllowAll set.
+	Allowed []string
+	// AllowAll disables the allowlist check
+	AllowAll bool
+	// Indexes are ensured on each tenant database the first time a session is created for it, keyed by collection name
+	Indexes map[string][]mgo.Index
+	// Bootstrap is called once per tenant database after the indexes are ensured
+	Bootstrap func(s *DbSession) error
+}
+
+// TenantRouter yields DbSession instances bound to a tenant database chosen at runtime, all sharing
+// the single cluster connection of the Db.
+// For example:
+//
+//	router := gmgo.NewTenantRouter(db, gmgo.TenantConfig{DatabasePrefix: "tenant_", Allowed: []string{"acme"}})
+//
+//	session, err := router.Session(gmgo.WithTenant(ctx, "acme"))
+//	if err != nil {
+//		return err
+//	}
+//	defer session.Close()
+//	err = session.Save(usr) // saved in the tenant_acme database
+type TenantRouter struct {
+	db     Db
+	config TenantConfig
+
+	mutex        sync.Mutex
+	allowed      map[string]bool
+	bootstrapped map[string]*tenantBootstrap
+}
+
+// tenantBootstrap is the bootstrap state of a tenant database. Each database has its own lock, so a slow bootstrap
+// only holds back the sessions of its tenant
+type tenantBootstrap struct {
+	mutex sync.Mutex
+	done  bool
+}
+
+// NewTenantRouter creates the tenant router for the database connection
+func NewTenantRouter(db Db, config TenantConfig) *TenantRouter {
+	tr := &TenantRouter{
+		db:           db,
+		config:       config,
+		allowed:      make(map[string]bool),
+		bootstrapped: make(map[string]*tenantBootstrap),
+	}
+	for _, tenantID := range config.Allowed {
+		tr.allowed[tenantID] = true
+	}
+	return tr
+}
+
+// AllowTenant adds the tenants to the allowlist
+func (tr *TenantRouter) AllowTenant(tenantIDs ...string) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	for _, tenantID := range tenantIDs {
+		tr.allowed[tenantID] = true
+	}
+}
+
+// RevokeTenant removes the tenants from the allowlist. Sessions already created are not affected.
+func (tr *TenantRouter) RevokeTenant(tenantIDs ...string) {
+	tr.mutex.Lock()
+	defer tr.mutex.Unlock()
+	for _, tenantID := range tenantIDs {
+		delete(tr.allowed, tenantID)
+	}
+}
+
+// DatabaseName returns the name of the tenant database
+func (tr *TenantRouter) DatabaseName(tenantID string) string {
+	if tr.config.DatabaseName != nil {
+		return tr.config.DatabaseName(tenantID)
+	}
+	return tr.config.DatabasePrefix + tenantID
+}
+
+// Session creates the session for the tenant resolved from the context
+func (tr *TenantRouter) Session(ctx context.Context) (*DbSession, error) {
+	var tenantID string
+	if tr.config.Resolver != nil {
+		var err error
+		if tenantID, err = tr.config.Resolver(ctx); err != nil {
+			return nil, err
+		}
+	} else {
+		var ok bool
+		if tenantID, ok = TenantFromContext(ctx); !ok {
+			return nil, ErrNoTenant
+		}
+	}
+	return tr.TenantSession(tenantID)
+}
+
+// TenantSession creates the session bound to the given tenant database. The session must be closed after use.
+func (tr *TenantRouter) TenantSession(tenantID string) (*DbSession, error) {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return nil, ErrInvalidTenant
+	}
+
+	tr.mutex.Lock()
+	allowed := tr.config.AllowAll || tr.allowed[tenantID]
+	tr.mutex.Unlock()
+	if !allowed {
+		return nil, ErrTenantNotAllowed
+	}
+
+	dbName := tr.DatabaseName(tenantID)
+	if !databaseNamePattern.MatchString(dbName) {
+		return nil, ErrInvalidDatabaseName
+	}
+
+	db := tr.db
+	db.Config.DBName = dbName
+	session := db.Session()
+
+	if err := tr.bootstrap(session); err != nil {
+		session.Close()
+		return nil, err
+	}
+	return session, nil
+}
+
+// bootstrap ensures the tenant indexes once per tenant database. A failed bootstrap is retried by the next session
+func (tr *TenantRouter) bootstrap(s *DbSession) error {
+	dbName := s.db.Config.DBName
+
+	tr.mutex.Lock()
+	state, ok := tr.bootstrapped[dbName]
+	if !ok {
+		state = new(tenantBootstrap)
+		tr.bootstrapped[dbName] = state
+	}
+	tr.mutex.Unlock()
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+	if state.done {
+		return nil
+	}
+
+	for collection, indexes := range tr.config.Indexes {
+		for _, index := range indexes {
+			if err := s.collection(collection).EnsureIndex(index); err != nil {
+				return err
+			}
+		}
+	}
+	if tr.config.Bootstrap != nil {
+		if err := tr.config.Bootstrap(s); err != nil {
+			return err
+		}
+	}
+
+	state.done = true
+	return nil
+}
@@ -0,0 +1,64 @@
+package gmgo
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTenantRouterAccess(t *testing.T) {
+	router := NewTenantRouter(Db{}, TenantConfig{DatabasePrefix: "tenant_", Allowed: []string{"acme"}})
+
+	if name := router.DatabaseName("acme"); name != "tenant_acme" {
+		t.Errorf("Expected tenant_acme, got %s", name)
+	}
+	if _, err := router.Session(context.Background()); err != ErrNoTenant {
+		t.Errorf("Expected ErrNoTenant, got %v", err)
+	}
+	if _, err := router.Session(WithTenant(context.Background(), "globex")); err != ErrTenantNotAllowed {
+		t.Errorf("Expected ErrTenantNotAllowed, got %v", err)
+	}
+	if _, err := router.TenantSession("../admin"); err != ErrInvalidTenant {
+		t.Errorf("Expected ErrInvalidTenant, got %v", err)
+	}
+
+	router.RevokeTenant("acme")
+	if _, err := router.TenantSession("acme"); err != ErrTenantNotAllowed {
+		t.Errorf("Expected revoked tenant to be rejected, got %v", err)
+	}
+}
+
+func TestTenantDatabaseNameValidated(t *testing.T) {
+	router := NewTenantRouter(Db{}, TenantConfig{AllowAll: true, DatabaseName: func(tenantID string) string {
+		return "tenants." + tenantID
+	}})
+	if _, err := router.TenantSession("acme"); err != ErrInvalidDatabaseName {
+		t.Errorf("Expected ErrInvalidDatabaseName, got %v", err)
+	}
+}
+
+func TestTenantBootstrapPerDatabase(t *testing.T) {
+	slow := make(chan struct{})
+	router := NewTenantRouter(Db{}, TenantConfig{AllowAll: true, Bootstrap: func(s *DbSession) error {
+		if s.db.Config.DBName == "tenant_slow" {
+			<-slow
+		}
+		return nil
+	}})
+	session := func(dbName string) *DbSession {
+		return &DbSession{db: Db{Config: DbConfig{DBName: dbName}}}
+	}
+
+	go router.bootstrap(session("tenant_slow"))
+	done := make(chan error)
+	go func() { done <- router.bootstrap(session("tenant_fast")) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Bootstrap failed %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Bootstrap of another tenant blocked by a slow bootstrap")
+	}
+	close(slow)
+}